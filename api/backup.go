@@ -10,9 +10,13 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	"github.com/pkg/errors"
 	"github.com/vtomasr5/mgob/backup"
 	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
 	"github.com/vtomasr5/mgob/notifier"
+	"github.com/vtomasr5/mgob/redact"
+	"github.com/vtomasr5/mgob/scheduler"
 )
 
 func configCtx(data config.AppConfig) func(next http.Handler) http.Handler {
@@ -24,31 +28,70 @@ func configCtx(data config.AppConfig) func(next http.Handler) http.Handler {
 	}
 }
 
+func notifierCtx(n *notifier.Notifier) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), "app.notifier", n))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func postBackup(w http.ResponseWriter, r *http.Request) {
 	cfg := r.Context().Value("app.config").(config.AppConfig)
+	n := r.Context().Value("app.notifier").(*notifier.Notifier)
+	sch := r.Context().Value("app.scheduler").(*scheduler.Scheduler)
 	planID := chi.URLParam(r, "planID")
 	plan, err := config.LoadPlan(cfg.ConfigPath, planID)
 	if err != nil {
 		render.Status(r, 500)
-		render.JSON(w, r, map[string]string{"error": err.Error()})
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
 		return
 	}
 
 	logrus.WithField("plan", planID).Info("On demand backup started")
+	defer sch.Running(plan.Name)()
+	t1 := time.Now()
 
 	res, err := backup.Run(plan, cfg.TmpPath, cfg.StoragePath)
-	if err != nil {
-		logrus.WithField("plan", planID).Errorf("On demand backup failed %v", err)
-		if err := notifier.SendNotification(fmt.Sprintf("%v on demand backup failed", planID),
+	if err := backup.UpdateCatalog(r.Context().Value("app.catalog").(*db.CatalogStore), res); err != nil {
+		logrus.WithField("plan", planID).Errorf("Catalog update failed %v", err)
+	}
+	if errors.Cause(err) == backup.ErrSkipped {
+		log := fmt.Sprintf("On demand backup skipped %v", err)
+		logrus.WithField("plan", planID).Warn(log)
+		sch.Record(plan, res, "204", log, nil, time.Now().Sub(t1))
+		render.Status(r, 503)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+	} else if err != nil {
+		log := fmt.Sprintf("On demand backup failed %v", err)
+		logrus.WithField("plan", planID).Error(log)
+		sch.Record(plan, res, "500", log, nil, time.Now().Sub(t1))
+		if err := n.SendNotification(fmt.Sprintf("%v on demand backup failed", planID),
 			err.Error(), true, plan); err != nil {
 			logrus.WithField("plan", plan.Name).Errorf("Notifier failed for on demand backup %v", err)
 		}
 		render.Status(r, 500)
-		render.JSON(w, r, map[string]string{"error": err.Error()})
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+	} else if res.Status == 206 {
+		log := fmt.Sprintf("On demand backup partially finished in %v archive %v size %v\n%v",
+			res.Duration, res.Name, humanize.Bytes(uint64(res.Size)), res.Summary())
+		logrus.WithField("plan", plan.Name).Warn(log)
+		sch.Record(plan, res, "206", log, nil, time.Now().Sub(t1))
+		if err := n.SendNotification(fmt.Sprintf("%v on demand backup partially failed", plan.Name),
+			fmt.Sprintf("%v backup finished in %v archive size %v\n%v",
+				res.Name, res.Duration, humanize.Bytes(uint64(res.Size)), res.Summary()),
+			true, plan); err != nil {
+			logrus.WithField("plan", plan.Name).Errorf("Notifier failed for on demand backup %v", err)
+		}
+		render.Status(r, 206)
+		render.JSON(w, r, toBackupResult(res))
 	} else {
-		logrus.WithField("plan", plan.Name).Infof("On demand backup finished in %v archive %v size %v",
+		log := fmt.Sprintf("On demand backup finished in %v archive %v size %v",
 			res.Duration, res.Name, humanize.Bytes(uint64(res.Size)))
-		if err := notifier.SendNotification(fmt.Sprintf("%v on demand backup finished", plan.Name),
+		logrus.WithField("plan", plan.Name).Info(log)
+		sch.Record(plan, res, "200", log, nil, time.Now().Sub(t1))
+		if err := n.SendNotification(fmt.Sprintf("%v on demand backup finished", plan.Name),
 			fmt.Sprintf("%v backup finished in %v archive size %v",
 				res.Name, res.Duration, humanize.Bytes(uint64(res.Size))),
 			false, plan); err != nil {
@@ -59,19 +102,37 @@ func postBackup(w http.ResponseWriter, r *http.Request) {
 }
 
 type backupResult struct {
-	Plan      string    `json:"plan"`
-	File      string    `json:"file"`
-	Duration  string    `json:"duration"`
-	Size      string    `json:"size"`
-	Timestamp time.Time `json:"timestamp"`
+	RunID        string               `json:"run_id,omitempty"`
+	Plan         string               `json:"plan"`
+	File         string               `json:"file"`
+	Duration     string               `json:"duration"`
+	Size         string               `json:"size"`
+	Timestamp    time.Time            `json:"timestamp"`
+	Member       string               `json:"member,omitempty"`
+	Database     string               `json:"database,omitempty"`
+	Error        string               `json:"error,omitempty"`
+	Checksum     string               `json:"checksum,omitempty"`
+	Destinations []backup.Destination `json:"destinations,omitempty"`
+	Databases    []backupResult       `json:"databases,omitempty"`
 }
 
 func toBackupResult(res backup.Result) backupResult {
+	var databases []backupResult
+	for _, db := range res.Databases {
+		databases = append(databases, toBackupResult(db))
+	}
 	return backupResult{
-		Plan:      res.Plan,
-		Duration:  fmt.Sprintf("%v", res.Duration),
-		File:      res.Name,
-		Size:      humanize.Bytes(uint64(res.Size)),
-		Timestamp: res.Timestamp,
+		RunID:        res.RunID,
+		Plan:         res.Plan,
+		Duration:     fmt.Sprintf("%v", res.Duration),
+		File:         res.Name,
+		Size:         humanize.Bytes(uint64(res.Size)),
+		Timestamp:    res.Timestamp,
+		Member:       res.Member,
+		Database:     res.Database,
+		Error:        res.Error,
+		Checksum:     res.Checksum,
+		Destinations: res.Destinations,
+		Databases:    databases,
 	}
 }
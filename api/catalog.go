@@ -0,0 +1,307 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/archive"
+	"github.com/vtomasr5/mgob/backup"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+	"github.com/vtomasr5/mgob/redact"
+)
+
+func catalogCtx(store *db.CatalogStore) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), "app.catalog", store))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func getBackups(w http.ResponseWriter, r *http.Request) {
+	listBackups(w, r, r.URL.Query().Get("plan"))
+}
+
+func getPlanBackups(w http.ResponseWriter, r *http.Request) {
+	listBackups(w, r, chi.URLParam(r, "planID"))
+}
+
+func listBackups(w http.ResponseWriter, r *http.Request, plan string) {
+	catalog := r.Context().Value("app.catalog").(*db.CatalogStore)
+	filter, err := catalogFilter(r)
+	if err != nil {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": err.Error()})
+		return
+	}
+	filter.Plan = plan
+
+	backups, err := catalog.List(filter)
+	if err != nil {
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+	render.JSON(w, r, backups)
+}
+
+// catalogFilter parses the database, status, destination, since, until and limit query params
+func catalogFilter(r *http.Request) (db.CatalogFilter, error) {
+	q := r.URL.Query()
+	filter := db.CatalogFilter{
+		Database:    q.Get("database"),
+		Destination: q.Get("destination"),
+	}
+
+	var err error
+	if v := q.Get("status"); v != "" {
+		if filter.Status, err = strconv.Atoi(v); err != nil {
+			return filter, errors.Errorf("invalid status %q", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			return filter, errors.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := q.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.Errorf("invalid since %q, the format is RFC3339", v)
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.Errorf("invalid until %q, the format is RFC3339", v)
+		}
+	}
+
+	return filter, nil
+}
+
+func postRescan(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	catalog := r.Context().Value("app.catalog").(*db.CatalogStore)
+
+	plans, err := config.LoadPlans(cfg.ConfigPath)
+	if err != nil {
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	logrus.Info("Catalog rescan started")
+	report, err := backup.Rescan(plans, r.URL.Query().Get("plan"), cfg.StoragePath, catalog)
+	if err != nil {
+		logrus.Errorf("Catalog rescan failed %v", err)
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+	logrus.Infof("Catalog rescan finished, %v plans", len(report.Plans))
+	render.JSON(w, r, report)
+}
+
+// backupPath returns the local copy of a plan archive, archives missing from the catalog are looked up in the plan storage
+func backupPath(catalog *db.CatalogStore, storagePath string, plan string, name string) (string, error) {
+	name = filepath.Base(name)
+	entry, err := catalog.Get(plan, name)
+	if err != nil {
+		return "", err
+	}
+
+	path := backup.LocalPath(storagePath, plan, name)
+	if entry != nil {
+		local, ok := entry.Copy("local")
+		if !ok {
+			return "", errors.Errorf("%v has no local copy", name)
+		}
+		path = filepath.Join(storagePath, local.Key)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", errors.Errorf("backup %v not found", name)
+	}
+	return path, nil
+}
+
+func getBackupContents(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	catalog := r.Context().Value("app.catalog").(*db.CatalogStore)
+	planID := chi.URLParam(r, "planID")
+
+	path, err := backupPath(catalog, cfg.StoragePath, planID, chi.URLParam(r, "backup"))
+	if err != nil {
+		render.Status(r, 404)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	// counting reads the whole archive, counts=false reads the prelude only
+	counts := r.URL.Query().Get("counts") != "false"
+	contents, err := archive.Inspect(path, counts)
+	if err != nil {
+		logrus.WithField("plan", planID).Errorf("Reading the contents of %v failed %v", filepath.Base(path), err)
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+	render.JSON(w, r, contents)
+}
+
+// getBackupCollection streams the documents of a collection out of a stored archive as
+// extended JSON lines or raw BSON, filtered on _id or on a field value
+func getBackupCollection(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	catalog := r.Context().Value("app.catalog").(*db.CatalogStore)
+	planID := chi.URLParam(r, "planID")
+	q := r.URL.Query()
+
+	ns := chi.URLParam(r, "ns")
+	dot := strings.Index(ns, ".")
+	if dot < 1 || dot == len(ns)-1 {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": fmt.Sprintf("invalid namespace %q, the format is db.collection", ns)})
+		return
+	}
+	database, collection := ns[:dot], ns[dot+1:]
+
+	format := q.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "bson" {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": fmt.Sprintf("invalid format %q, ndjson or bson", format)})
+		return
+	}
+
+	var filter *archive.Filter
+	if v := q.Get("id"); v != "" {
+		filter = &archive.Filter{ID: archive.ParseValue(v)}
+	}
+	if field := q.Get("field"); field != "" {
+		if filter == nil {
+			filter = &archive.Filter{}
+		}
+		filter.Field, filter.Value = field, archive.ParseValue(q.Get("value"))
+	}
+
+	path, err := backupPath(catalog, cfg.StoragePath, planID, chi.URLParam(r, "backup"))
+	if err != nil {
+		render.Status(r, 404)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	// the headers are sent with the first document so a missing namespace is still a 404
+	started := false
+	start := func() {
+		if format == "bson" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", ns+".bson"))
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.WriteHeader(200)
+		started = true
+	}
+
+	err = archive.Extract(path, database, collection, filter, func(doc []byte) error {
+		if !started {
+			start()
+		}
+		if format == "bson" {
+			_, err := w.Write(doc)
+			return err
+		}
+		return archive.WriteJSON(w, doc)
+	})
+	switch {
+	case err == archive.ErrNotFound:
+		render.Status(r, 404)
+		render.JSON(w, r, map[string]string{"error": fmt.Sprintf("%v not found in %v", ns, filepath.Base(path))})
+	case err != nil && !started:
+		logrus.WithField("plan", planID).Errorf("Reading %v from %v failed %v", ns, filepath.Base(path), err)
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+	case err != nil:
+		logrus.WithField("plan", planID).Errorf("Streaming %v from %v failed %v", ns, filepath.Base(path), err)
+	case !started:
+		start()
+	}
+}
+
+// getBackupDiff compares two backups of a plan, the two newest local backups of the same database without from and to
+func getBackupDiff(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	catalog := r.Context().Value("app.catalog").(*db.CatalogStore)
+	planID := chi.URLParam(r, "planID")
+	q := r.URL.Query()
+
+	from, to := q.Get("from"), q.Get("to")
+	if (from == "") != (to == "") {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": "from and to must be set together"})
+		return
+	}
+	if from == "" {
+		backups, err := catalog.List(db.CatalogFilter{Plan: planID, Database: q.Get("database")})
+		if err != nil {
+			render.Status(r, 500)
+			render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+			return
+		}
+		// the archives of a perDatabase plan are only compared with the ones of the same database
+		names := make([]string, 0, 2)
+		database := ""
+		for _, b := range backups {
+			if _, ok := b.Copy("local"); !ok || len(names) == 2 {
+				continue
+			}
+			if len(names) == 0 {
+				database = b.Database
+			}
+			if b.Database == database {
+				names = append(names, b.Archive)
+			}
+		}
+		if len(names) < 2 {
+			render.Status(r, 404)
+			render.JSON(w, r, map[string]string{"error": fmt.Sprintf("plan %v has less than two local backups", planID)})
+			return
+		}
+		from, to = names[1], names[0]
+	}
+
+	paths := make([]string, 0, 2)
+	for _, name := range []string{from, to} {
+		path, err := backupPath(catalog, cfg.StoragePath, planID, name)
+		if err != nil {
+			render.Status(r, 404)
+			render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+			return
+		}
+		paths = append(paths, path)
+	}
+
+	counts := q.Get("counts") != "false"
+	diff, err := archive.DiffFiles(paths[0], paths[1], counts)
+	if err != nil {
+		logrus.WithField("plan", planID).Errorf("Comparing %v with %v failed %v", from, to, err)
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+	render.JSON(w, r, diff)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/redact"
+	"github.com/vtomasr5/mgob/scheduler"
+	"gopkg.in/yaml.v2"
+)
+
+func schedulerCtx(sch *scheduler.Scheduler) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), "app.scheduler", sch))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func getPlans(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	files, err := config.PlanFiles(cfg.ConfigPath)
+	if err != nil {
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	redacted := make([]config.Plan, 0, len(files))
+	for _, path := range files {
+		plan, err := config.ReadPlanFile(path)
+		if err != nil {
+			render.Status(r, 500)
+			render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+			return
+		}
+		redacted = append(redacted, plan.Redacted())
+	}
+	render.JSON(w, r, redacted)
+}
+
+func getPlan(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	planID := chi.URLParam(r, "planID")
+	plan, err := config.ReadPlan(cfg.ConfigPath, planID)
+	if err != nil {
+		renderPlanError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, plan.Redacted())
+}
+
+// getEffectivePlan returns the plan merged with the plan it extends and the defaults
+func getEffectivePlan(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	planID := chi.URLParam(r, "planID")
+	plan, err := config.ReadEffectivePlan(cfg.ConfigPath, planID)
+	if err != nil {
+		renderPlanError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, plan.Redacted())
+}
+
+// putPlan creates or replaces a plan, the body can be YAML or JSON.
+// Environment and file references are kept as submitted.
+func putPlan(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	sch := r.Context().Value("app.scheduler").(*scheduler.Scheduler)
+	planID := chi.URLParam(r, "planID")
+
+	if err := config.ValidName(planID); err != nil {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	var plan config.Plan
+	if err := yaml.UnmarshalStrict(data, &plan); err != nil {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": errors.Wrap(err, "Parsing plan failed").Error()})
+		return
+	}
+
+	created := true
+	if prev, err := config.ReadPlanData(cfg.ConfigPath, planID); err == nil {
+		if data, err = config.UnredactPlan(data, prev); err != nil {
+			render.Status(r, 400)
+			render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+			return
+		}
+		created = false
+	}
+
+	// the submitted document is validated merged and resolved but saved as submitted,
+	// the keys it leaves out keep being inherited
+	if err := config.ValidatePlan(cfg.ConfigPath, planID, data); err != nil {
+		render.Status(r, 400)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	path, err := config.WritePlan(cfg.ConfigPath, planID, data)
+	if err != nil {
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+	logrus.WithField("plan", planID).Infof("Plan saved to %v", path)
+
+	if err := sch.Reload(); err != nil {
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	saved, err := config.ReadPlanFile(path)
+	if err != nil {
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+	if created {
+		render.Status(r, 201)
+	}
+	render.JSON(w, r, saved.Redacted())
+}
+
+func deletePlan(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	sch := r.Context().Value("app.scheduler").(*scheduler.Scheduler)
+	planID := chi.URLParam(r, "planID")
+
+	if err := config.DeletePlan(cfg.ConfigPath, planID); err != nil {
+		renderPlanError(w, r, err)
+		return
+	}
+	logrus.WithField("plan", planID).Info("Plan deleted")
+
+	if err := sch.Reload(); err != nil {
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
+func renderPlanError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Cause(err) == config.ErrPlanNotFound {
+		render.Status(r, 404)
+	} else {
+		render.Status(r, 500)
+	}
+	render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+	"github.com/vtomasr5/mgob/scheduler"
+)
+
+const testDefaults = `
+scheduler:
+  cron: "0 6 * * *"
+  retention: 7
+target:
+  type: standalone
+  backup:
+    host:
+      mongod:
+        - "db.local:27017"
+`
+
+const testParent = `
+scheduler:
+  cron: "0 1 * * *"
+  timeout: 10
+target:
+  type: standalone
+  backup:
+    host:
+      mongod:
+        - "parent.local:27017"
+smtp:
+  server: smtp.local
+  port: "465"
+  password: stored-secret
+  from: mgob@local
+  to:
+    - ops@local
+`
+
+// testScheduler is shared by the tests, the scheduler metrics can only be registered once
+func testScheduler(t *testing.T, dir string) *scheduler.Scheduler {
+	store, err := db.Open(filepath.Join(dir, "mgob.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	stats, err := db.NewStatusStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := db.NewCatalogStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sch := scheduler.New(nil, &config.AppConfig{}, stats, catalog)
+	t.Cleanup(func() { sch.Cron.Stop() })
+	return sch
+}
+
+func testPlansRouter(sch *scheduler.Scheduler, configPath string) http.Handler {
+	cfg := config.AppConfig{ConfigPath: configPath}
+	sch.Config = &cfg
+
+	r := chi.NewRouter()
+	r.Use(configCtx(cfg))
+	r.Use(schedulerCtx(sch))
+	r.Put("/plans/{planID}", putPlan)
+	return r
+}
+
+func TestPutPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		plan      string
+		body      string
+		code      int
+		stored    []string
+		missing   []string
+		effective func(p config.Plan) string
+	}{
+		{
+			name:    "partial plan inherits from defaults",
+			files:   map[string]string{"defaults.yml": testDefaults},
+			plan:    "partial",
+			body:    "scheduler:\n  timeout: 30\n",
+			code:    201,
+			stored:  []string{"timeout: 30"},
+			missing: []string{"cron", "retention", "type", "mongod", "mongos"},
+			effective: func(p config.Plan) string {
+				if p.Scheduler.Cron != "0 6 * * *" || p.Scheduler.Retention != 7 || p.Scheduler.Timeout != 30 {
+					return "scheduler not merged with the defaults"
+				}
+				if len(p.Target.Backup.Host.Mongod) != 1 || p.Target.Backup.Host.Mongod[0] != "db.local:27017" {
+					return "target not inherited from the defaults"
+				}
+				return ""
+			},
+		},
+		{
+			name:    "partial JSON plan extends a parent",
+			files:   map[string]string{"defaults.yml": testDefaults, "parent.yml": testParent},
+			plan:    "child",
+			body:    `{"extends": "parent", "scheduler": {"retention": 3}}`,
+			code:    201,
+			stored:  []string{"extends: parent", "retention: 3"},
+			missing: []string{"cron", "smtp"},
+			effective: func(p config.Plan) string {
+				if p.Scheduler.Cron != "0 1 * * *" || p.Scheduler.Timeout != 10 || p.Scheduler.Retention != 3 {
+					return "scheduler not merged with the parent"
+				}
+				if p.SMTP == nil || p.SMTP.Password != "stored-secret" {
+					return "smtp not inherited from the parent"
+				}
+				return ""
+			},
+		},
+		{
+			name:    "masked secret keeps the stored one",
+			files:   map[string]string{"defaults.yml": testDefaults, "parent.yml": testParent},
+			plan:    "parent",
+			body:    strings.Replace(testParent, "stored-secret", `"`+config.SecretMask+`"`, 1),
+			code:    200,
+			stored:  []string{"password: stored-secret"},
+			missing: []string{config.SecretMask},
+		},
+		{
+			name:  "missing required field",
+			files: map[string]string{},
+			plan:  "invalid",
+			body:  "scheduler:\n  timeout: 30\n",
+			code:  400,
+		},
+	}
+
+	dataDir, err := ioutil.TempDir("", "mgob-api-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	sch := testScheduler(t, dataDir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "mgob-api-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			configDir := filepath.Join(dir, "config")
+			if err := os.Mkdir(configDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(configDir, name), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("PUT", "/plans/"+tt.plan, strings.NewReader(tt.body))
+			testPlansRouter(sch, configDir).ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("status %v, want %v: %v", w.Code, tt.code, w.Body.String())
+			}
+			if tt.code >= 400 {
+				return
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(configDir, tt.plan+".yml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.stored {
+				if !strings.Contains(string(data), s) {
+					t.Errorf("stored plan doesn't contain %q:\n%s", s, data)
+				}
+			}
+			for _, s := range tt.missing {
+				if strings.Contains(string(data), s) {
+					t.Errorf("stored plan contains %q:\n%s", s, data)
+				}
+			}
+
+			if tt.effective != nil {
+				p, err := config.LoadPlanFile(filepath.Join(configDir, tt.plan+".yml"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg := tt.effective(p); msg != "" {
+					t.Error(msg)
+				}
+			}
+		})
+	}
+}
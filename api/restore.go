@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/backup"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+	"github.com/vtomasr5/mgob/notifier"
+	"github.com/vtomasr5/mgob/redact"
+)
+
+// selectBackupSet returns the named backup set or the newest one stored locally and its catalog
+// checksum. Sets missing from the catalog are looked up in the plan dir.
+func selectBackupSet(catalog *db.CatalogStore, storagePath string, plan string, name string) (string, string, error) {
+	planDir := filepath.Join(storagePath, plan)
+	var entry *db.Backup
+	if name != "" {
+		name = filepath.Base(name)
+		e, err := catalog.Get(plan, name)
+		if err != nil {
+			return "", "", err
+		}
+		entry = e
+	} else {
+		backups, err := catalog.List(db.CatalogFilter{Plan: plan, Destination: "local"})
+		if err != nil {
+			return "", "", err
+		}
+		for _, b := range backups {
+			if filepath.Ext(b.Archive) == ".tar" {
+				entry = b
+				break
+			}
+		}
+	}
+
+	if entry == nil {
+		if name != "" {
+			return filepath.Join(planDir, name), "", nil
+		}
+		archive, err := backup.LatestBackupSet(planDir)
+		return archive, "", err
+	}
+
+	local, ok := entry.Copy("local")
+	if !ok {
+		return "", "", errors.Errorf("%v has no local copy", entry.Archive)
+	}
+	return filepath.Join(storagePath, local.Key), entry.Checksum, nil
+}
+
+func postRestore(w http.ResponseWriter, r *http.Request) {
+	cfg := r.Context().Value("app.config").(config.AppConfig)
+	n := r.Context().Value("app.notifier").(*notifier.Notifier)
+	planID := chi.URLParam(r, "planID")
+	plan, err := config.LoadPlan(cfg.ConfigPath, planID)
+	if err != nil {
+		renderPlanError(w, r, err)
+		return
+	}
+
+	catalog := r.Context().Value("app.catalog").(*db.CatalogStore)
+	archive, sum, err := selectBackupSet(catalog, cfg.StoragePath, plan.Name, r.URL.Query().Get("backup"))
+	if err != nil {
+		render.Status(r, 404)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+	if sum != "" {
+		if err := backup.VerifyChecksum(archive, sum); err != nil {
+			render.Status(r, 409)
+			render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+			return
+		}
+	}
+
+	logrus.WithField("plan", planID).Infof("On demand restore of %v started", filepath.Base(archive))
+
+	res, err := backup.RestoreCluster(plan, archive, cfg.TmpPath, cfg.StoragePath)
+	if err != nil {
+		logrus.WithField("plan", planID).Errorf("On demand restore failed %v", err)
+		if err := n.SendNotification(fmt.Sprintf("%v restore failed", planID),
+			err.Error(), true, plan); err != nil {
+			logrus.WithField("plan", plan.Name).Errorf("Notifier failed for restore %v", err)
+		}
+		render.Status(r, 500)
+		render.JSON(w, r, map[string]string{"error": redact.Error(err)})
+		return
+	}
+
+	logrus.WithField("plan", plan.Name).Infof("On demand restore of %v finished in %v", res.Name, res.Duration)
+	if err := n.SendNotification(fmt.Sprintf("%v restore finished", plan.Name),
+		fmt.Sprintf("%v restore finished in %v", res.Name, res.Duration),
+		false, plan); err != nil {
+		logrus.WithField("plan", plan.Name).Errorf("Notifier failed for restore %v", err)
+	}
+	render.JSON(w, r, toBackupResult(res))
+}
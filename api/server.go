@@ -10,11 +10,14 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/vtomasr5/mgob/config"
 	"github.com/vtomasr5/mgob/db"
+	"github.com/vtomasr5/mgob/scheduler"
 )
 
 type HttpServer struct {
-	Config *config.AppConfig
-	Stats  *db.StatusStore
+	Config    *config.AppConfig
+	Stats     *db.StatusStore
+	Catalog   *db.CatalogStore
+	Scheduler *scheduler.Scheduler
 }
 
 func (s *HttpServer) Start(version string) {
@@ -40,9 +43,40 @@ func (s *HttpServer) Start(version string) {
 
 	r.Route("/backup", func(r chi.Router) {
 		r.Use(configCtx(*s.Config))
+		r.Use(catalogCtx(s.Catalog))
+		r.Use(notifierCtx(s.Scheduler.Notifier))
+		r.Use(schedulerCtx(s.Scheduler))
 		r.Post("/{planID}", postBackup)
 	})
 
+	r.Route("/backups", func(r chi.Router) {
+		r.Use(configCtx(*s.Config))
+		r.Use(catalogCtx(s.Catalog))
+		r.Get("/", getBackups)
+		r.Post("/rescan", postRescan)
+		r.Get("/{planID}", getPlanBackups)
+		r.Get("/{planID}/diff", getBackupDiff)
+		r.Get("/{planID}/{backup}/contents", getBackupContents)
+		r.Get("/{planID}/{backup}/collections/{ns}", getBackupCollection)
+	})
+
+	r.Route("/restore", func(r chi.Router) {
+		r.Use(configCtx(*s.Config))
+		r.Use(catalogCtx(s.Catalog))
+		r.Use(notifierCtx(s.Scheduler.Notifier))
+		r.Post("/{planID}", postRestore)
+	})
+
+	r.Route("/plans", func(r chi.Router) {
+		r.Use(configCtx(*s.Config))
+		r.Use(schedulerCtx(s.Scheduler))
+		r.Get("/", getPlans)
+		r.Get("/{planID}", getPlan)
+		r.Get("/{planID}/effective", getEffectivePlan)
+		r.Put("/{planID}", putPlan)
+		r.Delete("/{planID}", deletePlan)
+	})
+
 	FileServer(r, "/storage", http.Dir(s.Config.StoragePath))
 
 	logrus.Error(http.ListenAndServe(fmt.Sprintf(":%v", s.Config.Port), r))
@@ -7,6 +7,7 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	"github.com/vtomasr5/mgob/db"
+	"github.com/vtomasr5/mgob/redact"
 )
 
 type appStatus []*db.Status
@@ -21,7 +22,7 @@ func statusCtx(store *db.StatusStore) func(next http.Handler) http.Handler {
 			data, err := store.GetAll()
 			if err != nil {
 				render.Status(r, 500)
-				render.JSON(w, r, map[string]string{"error": err.Error()})
+				render.JSON(w, r, map[string]string{"error": redact.Error(err)})
 				return
 			}
 
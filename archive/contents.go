@@ -0,0 +1,152 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Contents lists the namespaces of a mongodump archive
+type Contents struct {
+	// Archive is the archive name inside a backup set
+	Archive   string     `json:"archive,omitempty"`
+	Header    Header     `json:"header"`
+	Databases []Database `json:"databases"`
+	// Oplog is the number of oplog entries dumped with --oplog
+	Oplog int64 `json:"oplog,omitempty"`
+}
+
+// Database lists the collections of a database
+type Database struct {
+	Name        string               `json:"name"`
+	Collections []CollectionContents `json:"collections"`
+}
+
+// CollectionContents describes a dumped collection, Count is set when the documents were counted
+type CollectionContents struct {
+	Name    string                   `json:"name"`
+	Type    string                   `json:"type,omitempty"`
+	Size    int64                    `json:"size"`
+	Count   *int64                   `json:"count,omitempty"`
+	Options map[string]interface{}   `json:"options,omitempty"`
+	Indexes []map[string]interface{} `json:"indexes,omitempty"`
+}
+
+// ReadContents reads the prelude of an archive and, with counts, every document to count them
+func ReadContents(r io.Reader, counts bool) (Contents, error) {
+	ar, err := NewReader(r)
+	if err != nil {
+		return Contents{}, err
+	}
+	defer ar.Close()
+
+	c := Contents{Header: ar.Header}
+	byNs := make(map[string]*int64)
+	dbs := make(map[string]*Database)
+	for _, coll := range ar.Collections {
+		meta, err := coll.ParseMetadata()
+		if err != nil {
+			return c, err
+		}
+		d, ok := dbs[coll.Database]
+		if !ok {
+			d = &Database{Name: coll.Database}
+			dbs[coll.Database] = d
+		}
+		cc := CollectionContents{
+			Name:    coll.Collection,
+			Type:    coll.Type,
+			Size:    coll.Size,
+			Options: meta.Options,
+			Indexes: meta.Indexes,
+		}
+		if counts && coll.Type != "view" {
+			cc.Count = new(int64)
+			byNs[coll.Namespace()] = cc.Count
+		}
+		d.Collections = append(d.Collections, cc)
+	}
+
+	if counts {
+		err := ar.Scan(func(db string, collection string, doc []byte) error {
+			if db == "" && collection == "oplog" {
+				c.Oplog++
+				return nil
+			}
+			if n, ok := byNs[db+"."+collection]; ok {
+				*n++
+			}
+			return nil
+		})
+		if err != nil {
+			return c, err
+		}
+	}
+
+	c.Databases = make([]Database, 0, len(dbs))
+	for _, d := range dbs {
+		sort.Slice(d.Collections, func(i, j int) bool { return d.Collections[i].Name < d.Collections[j].Name })
+		c.Databases = append(c.Databases, *d)
+	}
+	sort.Slice(c.Databases, func(i, j int) bool { return c.Databases[i].Name < c.Databases[j].Name })
+
+	return c, nil
+}
+
+// Inspect reads the contents of a .gz archive, or of every archive of a .tar backup set
+func Inspect(path string, counts bool) ([]Contents, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %v failed", path)
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) != ".tar" {
+		c, err := ReadContents(f, counts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %v failed", filepath.Base(path))
+		}
+		return []Contents{c}, nil
+	}
+
+	contents := make([]Contents, 0)
+	err = EachArchive(f, func(name string, r io.Reader) error {
+		c, err := ReadContents(r, counts)
+		if err != nil {
+			return errors.Wrapf(err, "reading %v failed", name)
+		}
+		c.Archive = name
+		contents = append(contents, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+// EachArchive calls fn for every .gz archive of a backup set
+func EachArchive(r io.Reader, fn func(name string, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "reading the backup set failed")
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".gz") {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
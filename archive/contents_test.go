@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestReadContents(t *testing.T) {
+	colls := append([]Collection{
+		{Database: "admin", Collection: "system.version", Size: 10},
+		{Database: "shop", Collection: "recent", Type: "view", Metadata: `{"options":{"viewOn":"orders"}}`},
+	}, testCollections...)
+	blocks := []testBlock{
+		{db: "shop", coll: "orders", docs: []bson.M{{"_id": 1}, {"_id": 2}}},
+		{db: "shop", coll: "users", docs: []bson.M{{"_id": "ann"}}},
+		{db: "shop", coll: "orders", docs: []bson.M{{"_id": 3}}},
+		{db: "", coll: "oplog", docs: []bson.M{{"op": "i"}, {"op": "u"}}},
+	}
+	data := testArchive(t, true, colls, blocks)
+
+	tests := []struct {
+		name   string
+		counts bool
+		want   map[string]int64
+		oplog  int64
+	}{
+		{name: "without counts"},
+		{
+			name:   "with counts",
+			counts: true,
+			want:   map[string]int64{"admin.system.version": 0, "shop.orders": 3, "shop.users": 1},
+			oplog:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ReadContents(bytes.NewReader(data), tt.counts)
+			if err != nil {
+				t.Fatalf("ReadContents() error %v", err)
+			}
+
+			names := make([]string, 0)
+			counts := make(map[string]int64)
+			for _, d := range c.Databases {
+				for _, coll := range d.Collections {
+					ns := d.Name + "." + coll.Name
+					names = append(names, ns)
+					if coll.Count != nil {
+						counts[ns] = *coll.Count
+					}
+				}
+			}
+			wantNames := []string{"admin.system.version", "shop.orders", "shop.recent", "shop.users"}
+			if !reflect.DeepEqual(names, wantNames) {
+				t.Errorf("namespaces %v, want %v", names, wantNames)
+			}
+			if tt.want == nil {
+				tt.want = map[string]int64{}
+			}
+			if !reflect.DeepEqual(counts, tt.want) {
+				t.Errorf("counts %v, want %v", counts, tt.want)
+			}
+			if c.Oplog != tt.oplog {
+				t.Errorf("oplog %v, want %v", c.Oplog, tt.oplog)
+			}
+			if c.Header.ServerVersion != "3.6.2" {
+				t.Errorf("header %+v", c.Header)
+			}
+
+			orders := c.Databases[1].Collections[0]
+			if orders.Size != 120 || len(orders.Indexes) != 1 || orders.Indexes[0]["name"] != "_id_" {
+				t.Errorf("shop.orders %+v", orders)
+			}
+			recent := c.Databases[1].Collections[1]
+			if recent.Type != "view" || recent.Options["viewOn"] != "orders" {
+				t.Errorf("shop.recent %+v", recent)
+			}
+		})
+	}
+}
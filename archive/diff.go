@@ -0,0 +1,173 @@
+package archive
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// Diff lists the namespaces that differ between two backups, the unchanged ones are only counted
+type Diff struct {
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	SizeFrom   int64           `json:"size_from"`
+	SizeTo     int64           `json:"size_to"`
+	Added      int             `json:"added"`
+	Removed    int             `json:"removed"`
+	Changed    int             `json:"changed"`
+	Unchanged  int             `json:"unchanged"`
+	Namespaces []NamespaceDiff `json:"namespaces"`
+}
+
+// NamespaceDiff is a collection added, removed or changed between two backups,
+// the counts are set when the documents were counted
+type NamespaceDiff struct {
+	Namespace      string   `json:"namespace"`
+	Status         string   `json:"status"`
+	SizeFrom       int64    `json:"size_from"`
+	SizeTo         int64    `json:"size_to"`
+	SizeDelta      int64    `json:"size_delta"`
+	CountFrom      *int64   `json:"count_from,omitempty"`
+	CountTo        *int64   `json:"count_to,omitempty"`
+	CountDelta     *int64   `json:"count_delta,omitempty"`
+	IndexesAdded   []string `json:"indexes_added,omitempty"`
+	IndexesRemoved []string `json:"indexes_removed,omitempty"`
+	IndexesChanged []string `json:"indexes_changed,omitempty"`
+}
+
+// Compare diffs the contents of two backups, the archives of a backup set are merged
+func Compare(from []Contents, to []Contents) Diff {
+	a, b := namespaces(from), namespaces(to)
+	d := Diff{Namespaces: make([]NamespaceDiff, 0)}
+
+	names := make([]string, 0, len(a)+len(b))
+	for ns, c := range a {
+		names = append(names, ns)
+		d.SizeFrom += c.Size
+	}
+	for ns, c := range b {
+		if _, ok := a[ns]; !ok {
+			names = append(names, ns)
+		}
+		d.SizeTo += c.Size
+	}
+	sort.Strings(names)
+
+	for _, ns := range names {
+		ca, inA := a[ns]
+		cb, inB := b[ns]
+		nd := NamespaceDiff{Namespace: ns}
+		switch {
+		case !inB:
+			nd.Status = "removed"
+			nd.SizeFrom, nd.CountFrom = ca.Size, ca.Count
+			d.Removed++
+		case !inA:
+			nd.Status = "added"
+			nd.SizeTo, nd.CountTo = cb.Size, cb.Count
+			d.Added++
+		default:
+			nd.Status = "changed"
+			nd.SizeFrom, nd.CountFrom = ca.Size, ca.Count
+			nd.SizeTo, nd.CountTo = cb.Size, cb.Count
+			nd.IndexesAdded, nd.IndexesRemoved, nd.IndexesChanged = compareIndexes(ca.Indexes, cb.Indexes)
+		}
+		nd.SizeDelta = nd.SizeTo - nd.SizeFrom
+		if nd.CountFrom != nil || nd.CountTo != nil {
+			delta := count(nd.CountTo) - count(nd.CountFrom)
+			nd.CountDelta = &delta
+		}
+
+		if nd.Status == "changed" && nd.SizeDelta == 0 && (nd.CountDelta == nil || *nd.CountDelta == 0) &&
+			len(nd.IndexesAdded)+len(nd.IndexesRemoved)+len(nd.IndexesChanged) == 0 {
+			d.Unchanged++
+			continue
+		}
+		if nd.Status == "changed" {
+			d.Changed++
+		}
+		d.Namespaces = append(d.Namespaces, nd)
+	}
+
+	return d
+}
+
+// namespaces indexes the collections by db.collection, a namespace found in several
+// archives of a set has its size and count summed
+func namespaces(contents []Contents) map[string]CollectionContents {
+	m := make(map[string]CollectionContents)
+	for _, c := range contents {
+		for _, d := range c.Databases {
+			for _, coll := range d.Collections {
+				ns := d.Name + "." + coll.Name
+				prev, ok := m[ns]
+				if !ok {
+					m[ns] = coll
+					continue
+				}
+				prev.Size += coll.Size
+				if prev.Count != nil && coll.Count != nil {
+					n := *prev.Count + *coll.Count
+					prev.Count = &n
+				}
+				m[ns] = prev
+			}
+		}
+	}
+	return m
+}
+
+// compareIndexes matches the indexes by name, an index whose definition differs is changed
+func compareIndexes(from []map[string]interface{}, to []map[string]interface{}) ([]string, []string, []string) {
+	a, b := indexesByName(from), indexesByName(to)
+	var added, removed, changed []string
+	for name, ia := range a {
+		ib, ok := b[name]
+		if !ok {
+			removed = append(removed, name)
+		} else if !reflect.DeepEqual(ia, ib) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+func indexesByName(indexes []map[string]interface{}) map[string]map[string]interface{} {
+	m := make(map[string]map[string]interface{}, len(indexes))
+	for _, idx := range indexes {
+		if name, ok := idx["name"].(string); ok {
+			m[name] = idx
+		}
+	}
+	return m
+}
+
+func count(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// DiffFiles inspects two stored backups and compares them
+func DiffFiles(from string, to string, counts bool) (Diff, error) {
+	a, err := Inspect(from, counts)
+	if err != nil {
+		return Diff{}, err
+	}
+	b, err := Inspect(to, counts)
+	if err != nil {
+		return Diff{}, err
+	}
+	d := Compare(a, b)
+	d.From, d.To = filepath.Base(from), filepath.Base(to)
+	return d, nil
+}
@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testContents(colls ...CollectionContents) Contents {
+	return Contents{Databases: []Database{{Name: "shop", Collections: colls}}}
+}
+
+func testCount(n int64) *int64 {
+	return &n
+}
+
+func TestCompare(t *testing.T) {
+	idIndex := map[string]interface{}{"name": "_id_", "key": map[string]interface{}{"_id": 1}}
+	emailIndex := map[string]interface{}{"name": "email_1", "key": map[string]interface{}{"email": 1}}
+	uniqueEmail := map[string]interface{}{"name": "email_1", "key": map[string]interface{}{"email": 1}, "unique": true}
+
+	tests := []struct {
+		name string
+		from []Contents
+		to   []Contents
+		want Diff
+	}{
+		{
+			name: "unchanged",
+			from: []Contents{testContents(CollectionContents{Name: "users", Size: 10, Count: testCount(2)})},
+			to:   []Contents{testContents(CollectionContents{Name: "users", Size: 10, Count: testCount(2)})},
+			want: Diff{SizeFrom: 10, SizeTo: 10, Unchanged: 1, Namespaces: []NamespaceDiff{}},
+		},
+		{
+			name: "added and removed",
+			from: []Contents{testContents(CollectionContents{Name: "carts", Size: 5})},
+			to:   []Contents{testContents(CollectionContents{Name: "orders", Size: 7})},
+			want: Diff{SizeFrom: 5, SizeTo: 7, Added: 1, Removed: 1, Namespaces: []NamespaceDiff{
+				{Namespace: "shop.carts", Status: "removed", SizeFrom: 5, SizeDelta: -5},
+				{Namespace: "shop.orders", Status: "added", SizeTo: 7, SizeDelta: 7},
+			}},
+		},
+		{
+			name: "size and count changed",
+			from: []Contents{testContents(CollectionContents{Name: "users", Size: 10, Count: testCount(2)})},
+			to:   []Contents{testContents(CollectionContents{Name: "users", Size: 4, Count: testCount(1)})},
+			want: Diff{SizeFrom: 10, SizeTo: 4, Changed: 1, Namespaces: []NamespaceDiff{
+				{Namespace: "shop.users", Status: "changed", SizeFrom: 10, SizeTo: 4, SizeDelta: -6,
+					CountFrom: testCount(2), CountTo: testCount(1), CountDelta: testCount(-1)},
+			}},
+		},
+		{
+			name: "indexes changed",
+			from: []Contents{testContents(CollectionContents{Name: "users", Size: 10,
+				Indexes: []map[string]interface{}{idIndex, emailIndex}})},
+			to: []Contents{testContents(CollectionContents{Name: "users", Size: 10,
+				Indexes: []map[string]interface{}{idIndex, uniqueEmail, {"name": "name_1"}}})},
+			want: Diff{SizeFrom: 10, SizeTo: 10, Changed: 1, Namespaces: []NamespaceDiff{
+				{Namespace: "shop.users", Status: "changed", SizeFrom: 10, SizeTo: 10,
+					IndexesAdded: []string{"name_1"}, IndexesChanged: []string{"email_1"}},
+			}},
+		},
+		{
+			name: "backup set archives are summed",
+			from: []Contents{
+				testContents(CollectionContents{Name: "orders", Size: 3, Count: testCount(1)}),
+				testContents(CollectionContents{Name: "orders", Size: 4, Count: testCount(2)}),
+			},
+			to:   []Contents{testContents(CollectionContents{Name: "orders", Size: 7, Count: testCount(3)})},
+			want: Diff{SizeFrom: 7, SizeTo: 7, Unchanged: 1, Namespaces: []NamespaceDiff{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Compare(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compare() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrNotFound is returned when no archive holds the namespace
+var ErrNotFound = errors.New("namespace not found")
+
+// Filter selects documents by _id or by the value of a field, dotted paths reach into
+// sub-documents. A nil filter matches every document.
+type Filter struct {
+	ID    interface{}
+	Field string
+	Value interface{}
+}
+
+// ParseValue decodes an extended JSON value, anything that isn't valid JSON is taken as a string
+func ParseValue(value string) interface{} {
+	var v struct {
+		V interface{} `json:"v"`
+	}
+	if err := bson.UnmarshalJSON([]byte(`{"v":`+value+`}`), &v); err != nil {
+		return value
+	}
+	return v.V
+}
+
+// Matches reports whether the raw BSON document is selected by the filter
+func (f *Filter) Matches(doc []byte) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	var m bson.M
+	if err := bson.Unmarshal(doc, &m); err != nil {
+		return false, errors.Wrap(err, "parsing document failed")
+	}
+	if f.ID != nil && !equal(m["_id"], f.ID) {
+		return false, nil
+	}
+	if f.Field != "" {
+		v, ok := lookup(m, f.Field)
+		if !ok || !equal(v, f.Value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func lookup(m bson.M, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	var v interface{} = m
+	for _, p := range parts {
+		doc, ok := v.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = doc[p]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// equal compares numbers by value whatever their BSON type
+func equal(a interface{}, b interface{}) bool {
+	fa, aok := number(a)
+	fb, bok := number(b)
+	if aok && bok {
+		return fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// Extract calls fn for every document of db.collection matching the filter, in a .gz archive
+// or in the archives of a .tar backup set. ErrNotFound is returned when no archive holds it.
+func Extract(path string, db string, collection string, filter *Filter, fn func(doc []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "opening %v failed", path)
+	}
+	defer f.Close()
+
+	found := false
+	extract := func(r io.Reader) error {
+		ar, err := NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer ar.Close()
+
+		has := false
+		for _, c := range ar.Collections {
+			if c.Database == db && c.Collection == collection {
+				has = true
+			}
+		}
+		if !has {
+			return nil
+		}
+		found = true
+		return ar.Scan(func(d string, c string, doc []byte) error {
+			if d != db || c != collection {
+				return nil
+			}
+			ok, err := filter.Matches(doc)
+			if err != nil || !ok {
+				return err
+			}
+			return fn(doc)
+		})
+	}
+
+	if filepath.Ext(path) == ".tar" {
+		err = EachArchive(f, func(name string, r io.Reader) error {
+			return errors.Wrapf(extract(r), "reading %v failed", name)
+		})
+	} else {
+		err = errors.Wrapf(extract(f), "reading %v failed", filepath.Base(path))
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// WriteJSON writes the raw BSON document as a single line of extended JSON, the field order is kept
+func WriteJSON(w io.Writer, doc []byte) error {
+	var d bson.D
+	if err := bson.Unmarshal(doc, &d); err != nil {
+		return errors.Wrap(err, "parsing document failed")
+	}
+	var buf bytes.Buffer
+	if err := writeValue(&buf, d); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeValue(buf *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case bson.D:
+		buf.WriteByte('{')
+		for i, e := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(e.Name)
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeValue(buf, e.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeValue(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		data, err := bson.MarshalJSON(t)
+		if err != nil {
+			return errors.Wrap(err, "encoding document failed")
+		}
+		buf.Write(bytes.TrimSpace(data))
+	}
+	return nil
+}
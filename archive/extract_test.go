@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestFilterMatches(t *testing.T) {
+	id := bson.ObjectIdHex("5a55c1b0e4b0a1b2c3d4e5f6")
+	doc, err := bson.Marshal(bson.M{
+		"_id":      id,
+		"status":   "paid",
+		"total":    42,
+		"ratio":    0.5,
+		"customer": bson.M{"name": "ann", "address": bson.M{"city": "Lyon"}},
+		"deleted":  nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   bool
+	}{
+		{name: "nil filter", filter: nil, want: true},
+		{name: "empty filter", filter: &Filter{}, want: true},
+		{name: "object id", filter: &Filter{ID: ParseValue(`{"$oid": "5a55c1b0e4b0a1b2c3d4e5f6"}`)}, want: true},
+		{name: "other object id", filter: &Filter{ID: ParseValue(`{"$oid": "5a55c1b0e4b0a1b2c3d4e5f7"}`)}, want: false},
+		{name: "object id as a string", filter: &Filter{ID: ParseValue("5a55c1b0e4b0a1b2c3d4e5f6")}, want: false},
+		{name: "string field", filter: &Filter{Field: "status", Value: ParseValue("paid")}, want: true},
+		{name: "quoted string field", filter: &Filter{Field: "status", Value: ParseValue(`"paid"`)}, want: true},
+		{name: "other string", filter: &Filter{Field: "status", Value: ParseValue("open")}, want: false},
+		{name: "int field matches a float", filter: &Filter{Field: "total", Value: ParseValue("42.0")}, want: true},
+		{name: "float field", filter: &Filter{Field: "ratio", Value: ParseValue("0.5")}, want: true},
+		{name: "number against a string", filter: &Filter{Field: "total", Value: ParseValue(`"42"`)}, want: false},
+		{name: "dotted path", filter: &Filter{Field: "customer.address.city", Value: ParseValue("Lyon")}, want: true},
+		{name: "dotted path through a scalar", filter: &Filter{Field: "status.city", Value: ParseValue("Lyon")}, want: false},
+		{name: "missing field", filter: &Filter{Field: "coupon", Value: ParseValue("null")}, want: false},
+		{name: "null field", filter: &Filter{Field: "deleted", Value: ParseValue("null")}, want: true},
+		{name: "id and field", filter: &Filter{ID: id, Field: "status", Value: "paid"}, want: true},
+		{name: "id and other field", filter: &Filter{ID: id, Field: "status", Value: "open"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.Matches(doc)
+			if err != nil {
+				t.Fatalf("Matches() error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMatchesInvalidDocument(t *testing.T) {
+	f := &Filter{Field: "status", Value: "paid"}
+	if _, err := f.Matches([]byte{5, 0, 0}); err == nil {
+		t.Error("Matches() of an invalid document succeeded")
+	}
+}
@@ -0,0 +1,203 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MagicNumber starts every mongodump archive
+const MagicNumber uint32 = 0x8199e26d
+
+// terminator ends the prelude and every namespace block
+const terminator uint32 = 0xffffffff
+
+// maxDocumentSize bounds the documents read, the server limit is 16MB plus some slack for the oplog
+const maxDocumentSize = 48 * 1024 * 1024
+
+// Header is the first document of the archive prelude
+type Header struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections" json:"concurrent_collections"`
+	FormatVersion         string `bson:"version" json:"version"`
+	ServerVersion         string `bson:"server_version" json:"server_version"`
+	ToolVersion           string `bson:"tool_version" json:"tool_version"`
+}
+
+// Collection is a prelude entry, the metadata holds the options and the indexes as extended JSON
+type Collection struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int64  `bson:"size"`
+	Type       string `bson:"type"`
+}
+
+// Namespace returns db.collection
+func (c Collection) Namespace() string {
+	return c.Database + "." + c.Collection
+}
+
+// Metadata is the parsed collection metadata
+type Metadata struct {
+	Options map[string]interface{}   `json:"options,omitempty"`
+	Indexes []map[string]interface{} `json:"indexes,omitempty"`
+}
+
+// ParseMetadata decodes the extended JSON metadata of a collection
+func (c Collection) ParseMetadata() (Metadata, error) {
+	var m Metadata
+	if c.Metadata == "" {
+		return m, nil
+	}
+	if err := json.Unmarshal([]byte(c.Metadata), &m); err != nil {
+		return m, errors.Wrapf(err, "parsing the metadata of %v failed", c.Namespace())
+	}
+	return m, nil
+}
+
+// namespaceHeader starts a block of documents of a single namespace, the last block
+// of a namespace has EOF set and no documents
+type namespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// Reader reads a mongodump archive, plain or gzipped
+type Reader struct {
+	Header      Header
+	Collections []Collection
+	r           *bufio.Reader
+	closer      io.Closer
+	buf         []byte
+}
+
+// NewReader reads the archive prelude, the documents are read with Scan
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	ar := &Reader{r: br}
+
+	// gzip streams start with 1f 8b
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening the gzip stream failed")
+		}
+		ar.r = bufio.NewReaderSize(gz, 64*1024)
+		ar.closer = gz
+	}
+
+	var magic uint32
+	if err := binary.Read(ar.r, binary.LittleEndian, &magic); err != nil {
+		return nil, errors.Wrap(err, "reading the archive magic number failed")
+	}
+	if magic != MagicNumber {
+		return nil, errors.Errorf("not a mongodump archive, magic number %#x", magic)
+	}
+
+	doc, err := ar.readDocument()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the archive header failed")
+	}
+	if err := bson.Unmarshal(doc, &ar.Header); err != nil {
+		return nil, errors.Wrap(err, "parsing the archive header failed")
+	}
+
+	for {
+		doc, err := ar.readDocument()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading the archive prelude failed")
+		}
+		if doc == nil {
+			break
+		}
+		var c Collection
+		if err := bson.Unmarshal(doc, &c); err != nil {
+			return nil, errors.Wrap(err, "parsing the archive prelude failed")
+		}
+		ar.Collections = append(ar.Collections, c)
+	}
+
+	return ar, nil
+}
+
+// Close releases the gzip stream, the underlying reader is left open
+func (ar *Reader) Close() error {
+	if ar.closer != nil {
+		return ar.closer.Close()
+	}
+	return nil
+}
+
+// Scan calls fn for every document of the archive with its namespace, the documents of
+// different namespaces are interleaved when the dump was concurrent.
+// The document is only valid until fn returns.
+func (ar *Reader) Scan(fn func(db string, collection string, doc []byte) error) error {
+	for {
+		doc, err := ar.readDocument()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			return errors.New("unexpected terminator, a namespace header was expected")
+		}
+		var ns namespaceHeader
+		if err := bson.Unmarshal(doc, &ns); err != nil {
+			return errors.Wrap(err, "parsing the namespace header failed")
+		}
+
+		for {
+			doc, err := ar.readDocument()
+			if err != nil {
+				return errors.Wrapf(err, "reading %v.%v failed", ns.Database, ns.Collection)
+			}
+			if doc == nil {
+				break
+			}
+			if ns.EOF {
+				return errors.Errorf("unexpected document after the end of %v.%v", ns.Database, ns.Collection)
+			}
+			if err := fn(ns.Database, ns.Collection, doc); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readDocument returns the next BSON document, nil on a terminator and io.EOF at the end of the archive.
+// The buffer is reused by the next call.
+func (ar *Reader) readDocument() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(ar.r, size[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.Wrap(err, "reading the archive failed")
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+	if n == terminator {
+		return nil, nil
+	}
+	if n < 5 || n > maxDocumentSize {
+		return nil, errors.Errorf("invalid document size %v", n)
+	}
+
+	if cap(ar.buf) < int(n) {
+		ar.buf = make([]byte, n)
+	}
+	doc := ar.buf[:n]
+	copy(doc, size[:])
+	if _, err := io.ReadFull(ar.r, doc[4:]); err != nil {
+		return nil, errors.Wrap(err, "reading the archive failed")
+	}
+	return doc, nil
+}
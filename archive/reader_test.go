@@ -0,0 +1,188 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// testBlock is a block of documents of a namespace, a block without documents ends the namespace
+type testBlock struct {
+	db   string
+	coll string
+	docs []bson.M
+}
+
+// testArchive writes a mongodump archive holding the prelude collections and the blocks,
+// every namespace of the prelude is ended after the blocks
+func testArchive(t *testing.T, gz bool, colls []Collection, blocks []testBlock) []byte {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		data, err := bson.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(data)
+	}
+	end := func() {
+		binary.Write(&buf, binary.LittleEndian, terminator)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, MagicNumber)
+	write(Header{ConcurrentCollections: 4, FormatVersion: "0.1", ServerVersion: "3.6.2", ToolVersion: "r3.6.2"})
+	for _, c := range colls {
+		write(c)
+	}
+	end()
+	for _, b := range blocks {
+		write(namespaceHeader{Database: b.db, Collection: b.coll})
+		for _, doc := range b.docs {
+			write(doc)
+		}
+		end()
+	}
+	for _, c := range colls {
+		write(namespaceHeader{Database: c.Database, Collection: c.Collection, EOF: true})
+		end()
+	}
+
+	if !gz {
+		return buf.Bytes()
+	}
+	var out bytes.Buffer
+	w := gzip.NewWriter(&out)
+	w.Write(buf.Bytes())
+	w.Close()
+	return out.Bytes()
+}
+
+var testCollections = []Collection{
+	{Database: "shop", Collection: "orders", Metadata: `{"indexes":[{"v":2,"key":{"_id":1},"name":"_id_"}]}`, Size: 120},
+	{Database: "shop", Collection: "users", Size: 80},
+}
+
+func TestNewReader(t *testing.T) {
+	plain := testArchive(t, false, testCollections, nil)
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{name: "plain", data: plain},
+		{name: "gzipped", data: testArchive(t, true, testCollections, nil)},
+		{name: "empty prelude", data: testArchive(t, false, nil, nil)},
+		{name: "not an archive", data: []byte("{\"not\": \"an archive\"}"), err: "not a mongodump archive"},
+		{name: "empty", data: []byte{}, err: "magic number"},
+		{name: "truncated prelude", data: plain[:40], err: "reading the archive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar, err := NewReader(bytes.NewReader(tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("NewReader() error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewReader() error %v", err)
+			}
+			defer ar.Close()
+
+			if ar.Header.ServerVersion != "3.6.2" || ar.Header.ConcurrentCollections != 4 {
+				t.Errorf("header %+v", ar.Header)
+			}
+			want := testCollections
+			if tt.name == "empty prelude" {
+				want = nil
+			}
+			if !reflect.DeepEqual(ar.Collections, want) {
+				t.Errorf("collections %+v, want %+v", ar.Collections, want)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks []testBlock
+		want   []string
+		err    string
+	}{
+		{
+			name: "no documents",
+			want: []string{},
+		},
+		{
+			name: "interleaved namespaces",
+			blocks: []testBlock{
+				{db: "shop", coll: "orders", docs: []bson.M{{"_id": 1}, {"_id": 2}}},
+				{db: "shop", coll: "users", docs: []bson.M{{"_id": "ann"}}},
+				{db: "shop", coll: "orders", docs: []bson.M{{"_id": 3}}},
+			},
+			want: []string{"shop.orders 1", "shop.orders 2", "shop.users ann", "shop.orders 3"},
+		},
+		{
+			name: "empty block",
+			blocks: []testBlock{
+				{db: "shop", coll: "orders"},
+				{db: "shop", coll: "users", docs: []bson.M{{"_id": "bob"}}},
+			},
+			want: []string{"shop.users bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar, err := NewReader(bytes.NewReader(testArchive(t, true, testCollections, tt.blocks)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ar.Close()
+
+			got := make([]string, 0)
+			err = ar.Scan(func(db string, collection string, doc []byte) error {
+				var m bson.M
+				if err := bson.Unmarshal(doc, &m); err != nil {
+					return err
+				}
+				got = append(got, fmt.Sprintf("%v.%v %v", db, collection, m["_id"]))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Scan() error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Scan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanDocumentAfterEOF(t *testing.T) {
+	data := testArchive(t, false, nil, nil)
+	var buf bytes.Buffer
+	buf.Write(data)
+	doc, _ := bson.Marshal(namespaceHeader{Database: "shop", Collection: "orders", EOF: true})
+	buf.Write(doc)
+	doc, _ = bson.Marshal(bson.M{"_id": 1})
+	buf.Write(doc)
+	binary.Write(&buf, binary.LittleEndian, terminator)
+
+	ar, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ar.Scan(func(db string, collection string, doc []byte) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "after the end of shop.orders") {
+		t.Errorf("Scan() error %v, want a document after the end error", err)
+	}
+}
@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/archive"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+)
+
+// ErrNoSample is returned when an archive of the run has no local copy to read, comparing
+// an incomplete sample would report the missing collections as disappeared
+var ErrNoSample = errors.New("no local copy of the archive")
+
+// NewSample reads the collections of the local archives of a run, the documents are counted
+// when the plan sets a count change
+func NewSample(plan config.Plan, res Result, storagePath string) (db.Sample, error) {
+	sample := db.Sample{
+		Timestamp:   res.Timestamp,
+		Archive:     res.Name,
+		Size:        res.Size,
+		Collections: make([]string, 0),
+	}
+	counts := plan.Anomaly != nil && plan.Anomaly.CountChange > 0
+	if counts {
+		sample.Counts = make(map[string]int64)
+	}
+
+	results := []Result{res}
+	if len(res.Databases) > 0 {
+		results = res.Databases
+	}
+	for _, r := range results {
+		read := false
+		for _, d := range r.Destinations {
+			if d.Name != "local" || d.Status != 200 {
+				continue
+			}
+			read = true
+			contents, err := archive.Inspect(filepath.Join(storagePath, d.Key), counts)
+			if err != nil {
+				return sample, err
+			}
+			for _, c := range contents {
+				for _, database := range c.Databases {
+					for _, coll := range database.Collections {
+						ns := database.Name + "." + coll.Name
+						sample.Collections = append(sample.Collections, ns)
+						if coll.Count != nil {
+							sample.Counts[ns] += *coll.Count
+						}
+					}
+				}
+			}
+		}
+		if !read {
+			return sample, ErrNoSample
+		}
+	}
+	sort.Strings(sample.Collections)
+
+	return sample, nil
+}
+
+// Anomalies compares a run with the plan history, the run is suspicious when its size differs from
+// the history average by more than sizeChange percent, when a collection of the previous run is
+// missing or when the document count of a collection changed by more than countChange percent
+func Anomalies(anomaly config.Anomaly, sample db.Sample, history []db.Sample) []string {
+	anomalies := make([]string, 0)
+	if len(history) < 1 {
+		return anomalies
+	}
+
+	if anomaly.SizeChange > 0 {
+		var total int64
+		for _, h := range history {
+			total += h.Size
+		}
+		avg := float64(total) / float64(len(history))
+		if avg > 0 {
+			change := (float64(sample.Size) - avg) / avg * 100
+			if change > anomaly.SizeChange || -change > anomaly.SizeChange {
+				anomalies = append(anomalies, fmt.Sprintf("size %v changed by %+.0f%% from the average %v of the last %v runs",
+					humanize.Bytes(uint64(sample.Size)), change, humanize.Bytes(uint64(avg)), len(history)))
+			}
+		}
+	}
+
+	last := history[len(history)-1]
+	current := make(map[string]bool, len(sample.Collections))
+	for _, ns := range sample.Collections {
+		current[ns] = true
+	}
+	for _, ns := range last.Collections {
+		if !current[ns] {
+			anomalies = append(anomalies, fmt.Sprintf("collection %v disappeared", ns))
+		}
+	}
+
+	if anomaly.CountChange > 0 && sample.Counts != nil && last.Counts != nil {
+		names := make([]string, 0, len(last.Counts))
+		for ns := range last.Counts {
+			names = append(names, ns)
+		}
+		sort.Strings(names)
+		for _, ns := range names {
+			prev := last.Counts[ns]
+			n, ok := sample.Counts[ns]
+			if !ok || prev == 0 {
+				continue
+			}
+			change := float64(n-prev) / float64(prev) * 100
+			if change > anomaly.CountChange || -change > anomaly.CountChange {
+				anomalies = append(anomalies, fmt.Sprintf("collection %v count changed by %+.0f%% from %v to %v", ns, change, prev, n))
+			}
+		}
+	}
+
+	return anomalies
+}
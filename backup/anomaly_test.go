@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+)
+
+func TestAnomalies(t *testing.T) {
+	sample := func(size int64, counts map[string]int64, colls ...string) db.Sample {
+		return db.Sample{Size: size, Collections: colls, Counts: counts}
+	}
+	history := []db.Sample{
+		sample(900, nil, "shop.orders", "shop.users"),
+		sample(1100, map[string]int64{"shop.orders": 100, "shop.users": 10}, "shop.orders", "shop.users"),
+	}
+
+	tests := []struct {
+		name    string
+		anomaly config.Anomaly
+		sample  db.Sample
+		history []db.Sample
+		want    []string
+	}{
+		{
+			name:    "no history",
+			anomaly: config.Anomaly{SizeChange: 10},
+			sample:  sample(0, nil),
+			want:    []string{},
+		},
+		{
+			name:    "within the size change",
+			anomaly: config.Anomaly{SizeChange: 50},
+			sample:  sample(1400, nil, "shop.orders", "shop.users"),
+			history: history,
+			want:    []string{},
+		},
+		{
+			name:    "size grew",
+			anomaly: config.Anomaly{SizeChange: 50},
+			sample:  sample(1600, nil, "shop.orders", "shop.users"),
+			history: history,
+			want:    []string{"size 1.6 kB changed by +60% from the average 1.0 kB of the last 2 runs"},
+		},
+		{
+			name:    "size shrank",
+			anomaly: config.Anomaly{SizeChange: 50},
+			sample:  sample(100, nil, "shop.orders", "shop.users"),
+			history: history,
+			want:    []string{"size 100 B changed by -90% from the average 1.0 kB of the last 2 runs"},
+		},
+		{
+			name:    "size change disabled",
+			sample:  sample(100, nil, "shop.orders", "shop.users"),
+			history: history,
+			want:    []string{},
+		},
+		{
+			name:    "collection disappeared",
+			anomaly: config.Anomaly{SizeChange: 50},
+			sample:  sample(1000, nil, "shop.orders", "shop.carts"),
+			history: history,
+			want:    []string{"collection shop.users disappeared"},
+		},
+		{
+			name:    "count changed",
+			anomaly: config.Anomaly{CountChange: 50},
+			sample:  sample(1000, map[string]int64{"shop.orders": 10, "shop.users": 14}, "shop.orders", "shop.users"),
+			history: history,
+			want:    []string{"collection shop.orders count changed by -90% from 100 to 10"},
+		},
+		{
+			name:    "counts compared with the previous run only",
+			anomaly: config.Anomaly{CountChange: 50},
+			sample:  sample(1000, nil, "shop.orders", "shop.users"),
+			history: history,
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Anomalies(tt.anomaly, tt.sample, tt.history)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Anomalies() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSampleWithoutLocalCopy(t *testing.T) {
+	plan := config.Plan{Name: "mongo", Anomaly: &config.Anomaly{SizeChange: 50}}
+	failed := []Destination{{Name: "local", Status: 500}, {Name: "s3", Status: 200, Key: "mongo.gz"}}
+
+	tests := []struct {
+		name string
+		res  Result
+	}{
+		{name: "failed local copy", res: Result{Plan: "mongo", Status: 206, Destinations: failed}},
+		{name: "failed database", res: Result{Plan: "mongo", Status: 206, Databases: []Result{
+			{Plan: "mongo", Database: "orders", Status: 500},
+			{Plan: "mongo", Database: "users", Status: 206, Destinations: failed},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSample(plan, tt.res, "/storage"); err != ErrNoSample {
+				t.Errorf("NewSample() error %v, want %v", err, ErrNoSample)
+			}
+		})
+	}
+}
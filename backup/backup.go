@@ -1,82 +1,291 @@
 package backup
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	sh "github.com/codeskyblue/go-sh"
 	"github.com/pkg/errors"
 	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/redact"
 )
 
+// Run dumps the target and copies the archive to every configured destination.
+// Each destination is handled independently, the result status is 200 when all
+// copies landed, 206 when at least one did and 500 when none did.
+// PerDatabase plans store each database on its own, see storeDatabases.
 func Run(plan config.Plan, tmpPath string, storagePath string) (Result, error) {
 	t1 := time.Now()
 	planDir := fmt.Sprintf("%v/%v", storagePath, plan.Name)
 
-	// TODO: Add support for backup ALL shards
-	archive, log, err := dump(plan, tmpPath, t1.UTC())
+	info, err := dump(plan, tmpPath, t1.UTC())
+	archive, log := info.Archive, info.Log
 	res := Result{
+		RunID:     newRunID(),
 		Plan:      plan.Name,
 		Timestamp: t1.UTC(),
 		Status:    500,
+		Member:    info.Member,
+		Stages:    info.Stages,
 	}
+	// the archives are gzipped by mongodump, compress is the backup set packing
+	if res.Stages == nil {
+		res.Stages = Stages{}
+	}
+	res.Stages["dump"] = time.Now().Sub(t1) - res.Stages["compress"]
 	_, res.Name = filepath.Split(archive)
 
 	if err != nil {
 		return res, err
 	}
 
-	err = sh.Command("mkdir", "-p", planDir).Run()
-	if err != nil {
-		return res, errors.Wrapf(err, "creating dir %v in %v failed", plan.Name, storagePath)
+	if plan.Target.Backup.PerDatabase != nil {
+		return storeDatabases(plan, res, info, planDir, t1)
 	}
 
+	res, err = store(plan, res, archive, planDir, log, info.Manifest, info.Metadata)
+	res.Duration = time.Now().Sub(t1)
+	return res, err
+}
+
+// storeDatabases stores every database archive in its own dir under planDir, the result
+// status is 200 when all databases were stored, 206 when at least one was and 500 when none was
+func storeDatabases(plan config.Plan, res Result, info dumpInfo, planDir string, t1 time.Time) (Result, error) {
+	if err := os.MkdirAll(planDir, 0755); err == nil {
+		if err := moveFile(info.Log, planDir); err != nil {
+			logrus.WithField("plan", plan.Name).Errorf("moving log failed %v", err)
+		} else if plan.Scheduler.Retention > 0 {
+			res.Stages.time("retention", func() error {
+				_, err := applyRetention(planDir, plan.Scheduler.Retention)
+				if err != nil {
+					logrus.WithField("plan", plan.Name).Errorf("retention job failed %v", err)
+				}
+				return err
+			})
+		}
+	}
+
+	// the run has no archive of its own, it's named after the archives prefix
+	res.Name = strings.TrimSuffix(res.Name, ".gz")
+	stored := 0
+	for _, d := range info.Databases {
+		_, name := filepath.Split(d.Archive)
+		dbRes := Result{
+			RunID:     res.RunID,
+			Name:      name,
+			Plan:      plan.Name,
+			Timestamp: res.Timestamp,
+			Status:    500,
+			Database:  d.Name,
+			Stages:    Stages{},
+		}
+		err := d.Err
+		if err == nil {
+			dbRes, err = store(plan, dbRes, d.Archive, filepath.Join(planDir, d.Name), d.Metadata)
+		}
+		if err != nil {
+			dbRes.Error = redact.Error(err)
+			logrus.WithField("plan", plan.Name).WithField("database", d.Name).Errorf("Backup failed %v", dbRes.Error)
+		} else {
+			stored++
+		}
+		res.Size += dbRes.Size
+		res.Databases = append(res.Databases, dbRes)
+	}
+	res.Duration = time.Now().Sub(t1)
+
+	switch {
+	case stored == len(res.Databases):
+		res.Status = 200
+	case stored > 0:
+		res.Status = 206
+	default:
+		return res, errors.Errorf("all databases failed\n%v", res.Summary())
+	}
+
+	return res, nil
+}
+
+// store copies the archive to planDir, applies the retention there and uploads it to the remote destinations
+func store(plan config.Plan, res Result, archive string, planDir string, extra ...string) (Result, error) {
 	fi, err := os.Stat(archive)
 	if err != nil {
 		return res, errors.Wrapf(err, "stat file %v failed", archive)
 	}
 	res.Size = fi.Size()
-
-	err = sh.Command("mv", archive, planDir).Run()
-	if err != nil {
-		return res, errors.Wrapf(err, "moving file from %v to %v failed", archive, planDir)
+	if res.Checksum, err = checksum(archive); err != nil {
+		return res, err
 	}
-
-	err = sh.Command("mv", log, planDir).Run()
-	if err != nil {
-		return res, errors.Wrapf(err, "moving file from %v to %v failed", log, planDir)
+	for _, f := range extra {
+		if strings.HasSuffix(f, MetadataExt) {
+			res.Metadata = filepath.Join(planDir, filepath.Base(f))
+		}
 	}
 
-	if plan.Scheduler.Retention > 0 {
-		err = applyRetention(planDir, plan.Scheduler.Retention)
-		if err != nil {
-			return res, errors.Wrap(err, "retention job failed")
+	// remote uploads read from the local copy, or from tmp if the local copy failed
+	file := archive
+	local := runDestination("local", func() (string, error) {
+		return localCopy(plan, archive, planDir, extra...)
+	})
+	local.Key = filepath.Join(plan.Name, res.Database, res.Name)
+	if local.Status == 200 {
+		file = filepath.Join(planDir, res.Name)
+		if plan.Scheduler.Retention > 0 {
+			var removed []string
+			res.Stages.time("retention", func() error {
+				var err error
+				removed, err = applyRetention(planDir, plan.Scheduler.Retention)
+				if err != nil {
+					logrus.WithField("plan", plan.Name).Errorf("retention job failed %v", err)
+				}
+				return err
+			})
+			for _, f := range removed {
+				if ext := filepath.Ext(f); ext == ".gz" || ext == ".tar" {
+					res.Pruned = append(res.Pruned, f)
+				}
+			}
 		}
+	} else if _, err := os.Stat(filepath.Join(planDir, res.Name)); err == nil {
+		file = filepath.Join(planDir, res.Name)
 	}
+	res.Destinations = append(res.Destinations, local)
 
-	file := filepath.Join(planDir, res.Name)
+	remotes := make([]Destination, 0)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	upload := func(name string, key string, fn func() (string, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := runDestination(name, fn)
+			d.Key = key
+			mu.Lock()
+			remotes = append(remotes, d)
+			mu.Unlock()
+		}()
+	}
 
 	if plan.SFTP != nil {
-		sftpOutput, err := sftpUpload(file, plan)
-		if err != nil {
-			return res, err
-		}
-		logrus.WithField("plan", plan.Name).Info(sftpOutput)
+		upload("sftp", filepath.Join(plan.SFTP.BackupDir, res.Name), func() (string, error) {
+			return sftpUpload(file, plan)
+		})
 	}
 
 	if plan.S3 != nil {
-		s3Output, err := s3Upload(file, plan)
-		if err != nil {
-			return res, err
+		upload("s3", plan.S3.Bucket+"/"+res.Name, func() (string, error) {
+			return s3Upload(file, plan)
+		})
+	}
+
+	wg.Wait()
+	res.Destinations = append(res.Destinations, remotes...)
+
+	for _, d := range res.Destinations {
+		entry := logrus.WithField("plan", plan.Name).WithField("destination", d.Name)
+		if res.Database != "" {
+			entry = entry.WithField("database", res.Database)
+		}
+		if d.Status == 200 {
+			entry.Info(d.Log)
+		} else {
+			entry.Error(d.Error)
 		}
-		logrus.WithField("plan", plan.Name).Infof("S3 upload finished %v", s3Output)
 	}
 
-	t2 := time.Now()
-	res.Status = 200
-	res.Duration = t2.Sub(t1)
+	failed := len(res.Failed())
+	switch {
+	case failed == 0:
+		res.Status = 200
+	case failed < len(res.Destinations):
+		res.Status = 206
+	default:
+		return res, errors.Errorf("all destinations failed\n%v", res.Summary())
+	}
+
 	return res, nil
 }
+
+// newRunID returns a random run identifier
+func newRunID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// checksum returns the sha256 of file
+func checksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %v failed", file)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "reading %v failed", file)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyChecksum fails when the sha256 of file isn't sum
+func VerifyChecksum(file string, sum string) error {
+	actual, err := checksum(file)
+	if err != nil {
+		return err
+	}
+	if actual != sum {
+		return errors.Errorf("%v checksum mismatch, expected %v got %v", filepath.Base(file), sum, actual)
+	}
+	return nil
+}
+
+func runDestination(name string, fn func() (string, error)) Destination {
+	t1 := time.Now()
+	output, err := fn()
+	d := Destination{
+		Name:     name,
+		Status:   200,
+		Duration: time.Now().Sub(t1),
+		Log:      output,
+	}
+	if err != nil {
+		d.Status = 500
+		d.Error = redact.Error(err)
+	}
+	return d
+}
+
+// localCopy moves the archive and its log, manifest and metadata, when present, to planDir
+func localCopy(plan config.Plan, archive string, planDir string, extra ...string) (string, error) {
+	if err := os.MkdirAll(planDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "creating dir %v in %v failed", plan.Name, filepath.Dir(planDir))
+	}
+
+	if err := moveFile(archive, planDir); err != nil {
+		return "", err
+	}
+
+	for _, f := range extra {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err == nil {
+			if err := moveFile(f, planDir); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return fmt.Sprintf("Local copy finished `%v`", planDir), nil
+}
@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+)
+
+// stoppedBalancers holds the restart funcs of the balancers stopped by the running backups
+var stoppedBalancers = struct {
+	sync.Mutex
+	restarts map[string]func() error
+}{restarts: make(map[string]func() error)}
+
+// stopBalancer stops the balancer and waits for the running round to finish, outside the settings window it fails.
+// The returned func starts the balancer again, unless it was already stopped before the backup,
+// it must be deferred so the balancer is restarted when the dump fails or panics.
+func stopBalancer(plan config.Plan, settings *config.Balancer, mc *MongoClient, log io.Writer) (func() error, error) {
+	noop := func() error { return nil }
+
+	if !settings.Allows(time.Now()) {
+		return noop, errors.Errorf("the balancer can be stopped only between %v and %v UTC",
+			settings.Window.Start, settings.Window.Stop)
+	}
+
+	status, err := mc.BalancerStatus()
+	if err != nil {
+		return noop, err
+	}
+	balancerLog(plan, log, "balancer status %v", status)
+	if status.Mode == "off" {
+		balancerLog(plan, log, "balancer already stopped, it will be left stopped")
+		return noop, nil
+	}
+
+	// a backup and a restore of the same plan may run at the same time
+	key := fmt.Sprintf("%v %p", plan.Name, mc)
+	var once sync.Once
+	var restartErr error
+	restart := func() error {
+		once.Do(func() {
+			stoppedBalancers.Lock()
+			delete(stoppedBalancers.restarts, key)
+			stoppedBalancers.Unlock()
+
+			if err := mc.BalancerStart(); err != nil {
+				balancerLog(plan, log, "balancer start failed %v", err)
+				restartErr = err
+				return
+			}
+			status, err := mc.BalancerStatus()
+			if err != nil {
+				restartErr = err
+				return
+			}
+			balancerLog(plan, log, "balancer started, %v", status)
+		})
+		return restartErr
+	}
+
+	balancerLog(plan, log, "stopping balancer")
+	// register before stopping so a shutdown during the call still restarts it
+	stoppedBalancers.Lock()
+	stoppedBalancers.restarts[key] = restart
+	stoppedBalancers.Unlock()
+	if err := mc.BalancerStop(); err != nil {
+		restart()
+		return noop, err
+	}
+
+	balancerLog(plan, log, "waiting for the running balancer round")
+	if err := mc.WaitBalancerIdle(settings.WaitTimeout()); err != nil {
+		restart()
+		return noop, err
+	}
+	balancerLog(plan, log, "balancer stopped")
+
+	return restart, nil
+}
+
+// RestartBalancers starts the balancers stopped by the backups still running,
+// it's called on shutdown so an interrupted backup doesn't leave a cluster unbalanced
+func RestartBalancers() {
+	stoppedBalancers.Lock()
+	restarts := make(map[string]func() error, len(stoppedBalancers.restarts))
+	for name, restart := range stoppedBalancers.restarts {
+		restarts[name] = restart
+	}
+	stoppedBalancers.Unlock()
+
+	for key, restart := range restarts {
+		plan := strings.Fields(key)[0]
+		if err := restart(); err != nil {
+			logrus.WithField("plan", plan).Errorf("Restarting the balancer failed %v", err)
+		} else {
+			logrus.WithField("plan", plan).Info("Balancer restarted")
+		}
+	}
+}
+
+func balancerLog(plan config.Plan, log io.Writer, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintf(log, "%v\t%v\n", time.Now().Format("2006-01-02T15:04:05.000-0700"), msg)
+	logrus.WithField("plan", plan.Name).Info(msg)
+}
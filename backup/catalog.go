@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/db"
+)
+
+// CatalogEntries returns the catalog records of a run, one per stored archive
+func CatalogEntries(res Result) []*db.Backup {
+	if len(res.Databases) > 0 {
+		entries := make([]*db.Backup, 0, len(res.Databases))
+		for _, dbRes := range res.Databases {
+			entries = append(entries, CatalogEntries(dbRes)...)
+		}
+		return entries
+	}
+
+	entry := &db.Backup{
+		Plan:             res.Plan,
+		RunID:            res.RunID,
+		Archive:          res.Name,
+		Timestamp:        res.Timestamp,
+		Status:           res.Status,
+		Size:             res.Size,
+		Checksum:         res.Checksum,
+		Codec:            "gzip",
+		Encryption:       "none",
+		Database:         res.Database,
+		MongodumpVersion: MongodumpVersion(),
+	}
+	for _, d := range res.Destinations {
+		if d.Status == 200 {
+			entry.Copies = append(entry.Copies, db.Copy{Destination: d.Name, Key: d.Key})
+		}
+	}
+	if len(entry.Copies) == 0 {
+		return nil
+	}
+	if res.Metadata != "" {
+		if meta, err := ReadMetadata(res.Metadata); err == nil {
+			entry.ServerVersion = meta.Version
+			for _, d := range meta.Databases {
+				entry.Databases = append(entry.Databases, d.Name)
+			}
+		}
+	}
+
+	return []*db.Backup{entry}
+}
+
+// UpdateCatalog records the archives of a run and drops the local copies removed by the retention
+func UpdateCatalog(catalog *db.CatalogStore, res Result) error {
+	for _, entry := range CatalogEntries(res) {
+		if err := catalog.Put(entry); err != nil {
+			return errors.Wrapf(err, "recording %v in the catalog failed", entry.Archive)
+		}
+	}
+
+	results := append([]Result{res}, res.Databases...)
+	for _, r := range results {
+		for _, name := range r.Pruned {
+			if err := catalog.RemoveCopy(r.Plan, name, "local"); err != nil {
+				return errors.Wrapf(err, "removing %v from the catalog failed", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// LocalPath returns the storage path of a plan archive, the archives of a perDatabase plan
+// are stored in the database dir
+func LocalPath(storagePath string, plan string, name string) string {
+	name = filepath.Base(name)
+	if a, ok := parseArchiveName(name, []string{plan}); ok && a.Database != "" {
+		return filepath.Join(storagePath, plan, a.Database, name)
+	}
+	return filepath.Join(storagePath, plan, name)
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vtomasr5/mgob/db"
+)
+
+func TestCatalogEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metadata := filepath.Join(dir, "mongo-2018-01-10T06:00:00.json")
+	meta := Metadata{
+		Version:   "3.6.2",
+		Databases: []DatabaseMetadata{{Name: "orders"}, {Name: "users"}},
+	}
+	if err := writeMetadata(metadata, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2018, 1, 10, 6, 0, 0, 0, time.UTC)
+	local := Destination{Name: "local", Status: 200, Key: "/storage/mongo/mongo-2018-01-10T06:00:00.gz"}
+	s3 := Destination{Name: "s3", Status: 200, Key: "bucket/mongo-2018-01-10T06:00:00.gz"}
+	failed := Destination{Name: "sftp", Status: 500, Error: "connection refused"}
+
+	tests := []struct {
+		name      string
+		res       Result
+		archives  []string
+		copies    [][]db.Copy
+		databases [][]string
+	}{
+		{
+			name:      "all copies stored",
+			res:       Result{Plan: "mongo", Name: "mongo-2018-01-10T06:00:00.gz", Status: 200, Timestamp: ts, Destinations: []Destination{local, s3}},
+			archives:  []string{"mongo-2018-01-10T06:00:00.gz"},
+			copies:    [][]db.Copy{{{Destination: "local", Key: local.Key}, {Destination: "s3", Key: s3.Key}}},
+			databases: [][]string{nil},
+		},
+		{
+			name:      "failed copy left out",
+			res:       Result{Plan: "mongo", Name: "mongo-2018-01-10T06:00:00.gz", Status: 206, Timestamp: ts, Destinations: []Destination{local, failed}},
+			archives:  []string{"mongo-2018-01-10T06:00:00.gz"},
+			copies:    [][]db.Copy{{{Destination: "local", Key: local.Key}}},
+			databases: [][]string{nil},
+		},
+		{
+			name: "no copy stored",
+			res:  Result{Plan: "mongo", Name: "mongo-2018-01-10T06:00:00.gz", Status: 500, Timestamp: ts, Destinations: []Destination{failed}},
+		},
+		{
+			name:      "metadata databases",
+			res:       Result{Plan: "mongo", Name: "mongo-2018-01-10T06:00:00.gz", Status: 200, Timestamp: ts, Destinations: []Destination{local}, Metadata: metadata},
+			archives:  []string{"mongo-2018-01-10T06:00:00.gz"},
+			copies:    [][]db.Copy{{{Destination: "local", Key: local.Key}}},
+			databases: [][]string{{"orders", "users"}},
+		},
+		{
+			name: "per database archives",
+			res: Result{Plan: "mongo", Status: 206, Timestamp: ts, Databases: []Result{
+				{Plan: "mongo", Database: "orders", Name: "mongo-orders-2018-01-10T06:00:00.gz", Status: 200, Destinations: []Destination{local}},
+				{Plan: "mongo", Database: "users", Name: "mongo-users-2018-01-10T06:00:00.gz", Status: 500, Destinations: []Destination{failed}},
+				{Plan: "mongo", Database: "logs", Name: "mongo-logs-2018-01-10T06:00:00.gz", Status: 200, Destinations: []Destination{s3}},
+			}},
+			archives:  []string{"mongo-orders-2018-01-10T06:00:00.gz", "mongo-logs-2018-01-10T06:00:00.gz"},
+			copies:    [][]db.Copy{{{Destination: "local", Key: local.Key}}, {{Destination: "s3", Key: s3.Key}}},
+			databases: [][]string{nil, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := CatalogEntries(tt.res)
+			if len(entries) != len(tt.archives) {
+				t.Fatalf("CatalogEntries() returned %v entries, want %v", len(entries), len(tt.archives))
+			}
+			for i, entry := range entries {
+				if entry.Archive != tt.archives[i] {
+					t.Errorf("entry %v archive = %v, want %v", i, entry.Archive, tt.archives[i])
+				}
+				if !reflect.DeepEqual(entry.Copies, tt.copies[i]) {
+					t.Errorf("entry %v copies = %v, want %v", i, entry.Copies, tt.copies[i])
+				}
+				if !reflect.DeepEqual(entry.Databases, tt.databases[i]) {
+					t.Errorf("entry %v databases = %v, want %v", i, entry.Databases, tt.databases[i])
+				}
+				if entry.Plan != "mongo" || entry.Codec != "gzip" {
+					t.Errorf("entry %v = %+v, want the mongo plan and the gzip codec", i, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		plan string
+		name string
+		want string
+	}{
+		{plan: "mongo", name: "mongo-2018-01-10T06:00:00.gz", want: "/storage/mongo/mongo-2018-01-10T06:00:00.gz"},
+		{plan: "mongo", name: "mongo-2018-01-10T06:00:00.tar", want: "/storage/mongo/mongo-2018-01-10T06:00:00.tar"},
+		{plan: "mongo", name: "mongo-orders-2018-01-10T06:00:00.gz", want: "/storage/mongo/orders/mongo-orders-2018-01-10T06:00:00.gz"},
+		{plan: "mongo", name: "mongo-1515564000.gz", want: "/storage/mongo/mongo-1515564000.gz"},
+		{plan: "mongo", name: "../../etc/mongo-2018-01-10T06:00:00.gz", want: "/storage/mongo/mongo-2018-01-10T06:00:00.gz"},
+		{plan: "mongo", name: "unknown.gz", want: "/storage/mongo/unknown.gz"},
+	}
+
+	for _, tt := range tests {
+		if got := LocalPath("/storage", tt.plan, tt.name); got != tt.want {
+			t.Errorf("LocalPath(%q, %q) = %v, want %v", tt.plan, tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,34 +1,57 @@
 package backup
 
 import (
-	"strings"
+	"regexp"
+	"sync"
 
-	"github.com/codeskyblue/go-sh"
 	"github.com/pkg/errors"
 )
 
 func CheckMongodump() (string, error) {
-	output, err := sh.Command("/bin/sh", "-c", "mongodump --version").CombinedOutput()
+	res, err := Command{Name: "mongodump", Args: []string{"--version"}}.Run()
 	if err != nil {
-		ex := ""
-		if len(output) > 0 {
-			ex = strings.Replace(string(output), "\n", " ", -1)
+		return "", errors.Wrap(err, "mongodump failed")
+	}
+
+	return res.Output(), nil
+}
+
+// mongodumpVersionRegexp matches the version in the mongodump --version output, its lines are joined by Output
+var mongodumpVersionRegexp = regexp.MustCompile(`mongodump version: (\S+)`)
+
+var mongodumpVersion struct {
+	sync.Once
+	version string
+}
+
+// MongodumpVersion returns the mongodump version, empty when it can't be run
+func MongodumpVersion() string {
+	mongodumpVersion.Do(func() {
+		out, err := CheckMongodump()
+		if err != nil {
+			return
+		}
+		if m := mongodumpVersionRegexp.FindStringSubmatch(out); m != nil {
+			mongodumpVersion.version = m[1]
 		}
-		return "", errors.Wrapf(err, "mongodump failed %v", ex)
+	})
+	return mongodumpVersion.version
+}
+
+func CheckMongorestore() (string, error) {
+	res, err := Command{Name: "mongorestore", Args: []string{"--version"}}.Run()
+	if err != nil {
+		return "", errors.Wrap(err, "mongorestore failed")
 	}
 
-	return strings.Replace(string(output), "\n", " ", -1), nil
+	return res.Output(), nil
 }
 
 func CheckMinioClient() (string, error) {
-	output, err := sh.Command("/bin/sh", "-c", "mc version").CombinedOutput()
+	res, err := Command{Name: "mc", Args: []string{"version"}}.Run()
 	if err != nil {
-		ex := ""
-		if len(output) > 0 {
-			ex = strings.Replace(string(output), "\n", " ", -1)
-		}
-		return "", errors.Wrapf(err, "mc failed %v", ex)
+		return "", errors.Wrap(err, "mc failed")
 	}
 
-	return strings.Replace(string(output), "\n", " ", -1), nil
+	return res.Output(), nil
 }
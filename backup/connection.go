@@ -0,0 +1,310 @@
+package backup
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// toolArgs returns the auth and TLS arguments shared by mongodump and mongorestore.
+// The secrets are written to a config file inside dir, call cleanup once the tool exited.
+func toolArgs(conn config.Connection, dir string) ([]string, func(), error) {
+	args := []string{}
+	files := []string{}
+	cleanup := func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}
+	secrets := map[string]string{}
+
+	if conn.URI != "" {
+		// the URI may hold the password
+		secrets["uri"] = conn.URI
+	}
+	if conn.Username != "" {
+		args = append(args, "--username", conn.Username)
+	}
+	if conn.Password != "" {
+		secrets["password"] = conn.Password
+	}
+	if source := conn.Source(); source != "" {
+		args = append(args, "--authenticationDatabase", source)
+	}
+	if conn.AuthMechanism != "" {
+		args = append(args, "--authenticationMechanism", conn.AuthMechanism)
+	}
+
+	if conn.TLS != nil {
+		args = append(args, "--ssl")
+		if conn.TLS.CAFile != "" {
+			args = append(args, "--sslCAFile", conn.TLS.CAFile)
+		}
+		if conn.TLS.CertFile != "" {
+			// the tools expect the certificate and the key in the same PEM file
+			pemFile := conn.TLS.CertFile
+			if conn.TLS.KeyFile != "" && conn.TLS.KeyFile != conn.TLS.CertFile {
+				f, err := writePEMKeyFile(conn.TLS, dir)
+				if err != nil {
+					return nil, cleanup, err
+				}
+				files = append(files, f)
+				pemFile = f
+			}
+			args = append(args, "--sslPEMKeyFile", pemFile)
+		}
+		if conn.TLS.KeyPassword != "" {
+			secrets["sslPEMKeyPassword"] = conn.TLS.KeyPassword
+		}
+		if conn.TLS.AllowInvalidHostnames {
+			args = append(args, "--sslAllowInvalidHostnames")
+		}
+	}
+
+	if len(secrets) > 0 {
+		// the secrets are passed in a config file so they don't show up in ps
+		f, err := writeToolConfig(dir, secrets)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		files = append(files, f)
+		args = append(args, "--config="+f)
+	}
+
+	return args, cleanup, nil
+}
+
+// seeds returns the hosts to dial, the mongodb+srv:// host name is resolved
+// with a DNS SRV lookup and its TXT record provides the default options
+func seeds(conn config.Connection) (config.Connection, []string, error) {
+	if conn.URI == "" {
+		return conn, nil, nil
+	}
+	if !conn.SRV {
+		return conn, conn.Seeds, nil
+	}
+
+	name := conn.Seeds[0]
+	_, addrs, err := net.LookupSRV("mongodb", "tcp", name)
+	if err != nil {
+		return conn, nil, errors.Wrapf(err, "SRV lookup of %v failed", name)
+	}
+	hosts := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		hosts = append(hosts, net.JoinHostPort(strings.TrimSuffix(a.Target, "."), strconv.Itoa(int(a.Port))))
+	}
+
+	txt, err := net.LookupTXT(name)
+	if err == nil && len(txt) > 0 {
+		opts, err := url.ParseQuery(strings.Join(txt, ""))
+		if err != nil {
+			return conn, nil, errors.Wrapf(err, "invalid TXT record of %v", name)
+		}
+		for k, v := range opts {
+			switch strings.ToLower(k) {
+			case "replicaset":
+				if conn.ReplicaSet == "" {
+					conn.ReplicaSet = v[0]
+				}
+			case "authsource":
+				if conn.AuthSource == "" {
+					conn.AuthSource = v[0]
+				}
+			}
+		}
+	}
+
+	return conn, hosts, nil
+}
+
+// hostArg returns the mongo tools --host value, replica set members are prefixed with the set name
+func hostArg(conn config.Connection, hosts ...string) string {
+	host := strings.Join(hosts, ",")
+	if conn.ReplicaSet != "" {
+		return conn.ReplicaSet + "/" + host
+	}
+	return host
+}
+
+// writeToolConfig saves the secrets to a file readable only by mgob,
+// the file is passed to the mongo tools with --config
+func writeToolConfig(dir string, secrets map[string]string) (string, error) {
+	data, err := yaml.Marshal(secrets)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling mongo tools config failed")
+	}
+
+	return writePrivateFile(dir, ".mgob-tools-", data)
+}
+
+func writePEMKeyFile(t *config.TLS, dir string) (string, error) {
+	cert, err := ioutil.ReadFile(t.CertFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading TLS certificate %v failed", t.CertFile)
+	}
+	key, err := ioutil.ReadFile(t.KeyFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading TLS key %v failed", t.KeyFile)
+	}
+
+	data := append(append(cert, '\n'), key...)
+	return writePrivateFile(dir, ".mgob-pem-", data)
+}
+
+func writePrivateFile(dir string, prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return "", errors.Wrapf(err, "creating file in %v failed", dir)
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0600); err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "chmod %v failed", f.Name())
+	}
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "writing %v failed", f.Name())
+	}
+
+	return f.Name(), nil
+}
+
+// mgoAuth fails for the mechanisms only mongodump and mongorestore support, mgo would
+// downgrade SCRAM-SHA-256 to SCRAM-SHA-1
+func mgoAuth(conn config.Connection) error {
+	if conn.AuthMechanism == config.AuthScramSHA256 {
+		return errors.Errorf("the mgo driver used for the member selection, the balancer, the filters and the metadata doesn't support %v", config.AuthScramSHA256)
+	}
+	return nil
+}
+
+// dialInfo returns the mgo settings matching the connection
+func dialInfo(conn config.Connection, hosts ...string) (*mgo.DialInfo, error) {
+	if err := mgoAuth(conn); err != nil {
+		return nil, err
+	}
+	info := &mgo.DialInfo{
+		Addrs:          hosts,
+		Timeout:        30 * time.Second,
+		Username:       conn.Username,
+		Password:       conn.Password,
+		Source:         conn.Source(),
+		ReplicaSetName: conn.ReplicaSet,
+		Mechanism:      conn.AuthMechanism,
+	}
+
+	if conn.TLS != nil {
+		tlsConfig, err := tlsConfig(conn.TLS)
+		if err != nil {
+			return nil, err
+		}
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.Dial("tcp", addr.String(), tlsConfig)
+		}
+	}
+
+	return info, nil
+}
+
+func tlsConfig(t *config.TLS) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if t.CAFile != "" {
+		ca, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading TLS CA %v failed", t.CAFile)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificate found in TLS CA %v", t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" {
+		keyFile := t.KeyFile
+		if keyFile == "" {
+			keyFile = t.CertFile
+		}
+		cert, err := loadKeyPair(t.CertFile, keyFile, t.KeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if t.AllowInvalidHostnames {
+		// verify the chain but not the host name
+		cfg.InsecureSkipVerify = true
+		roots := cfg.RootCAs
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, 0, len(raw))
+			for _, r := range raw {
+				c, err := x509.ParseCertificate(r)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, c)
+			}
+			if len(certs) < 1 {
+				return errors.New("no server certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, c := range certs[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadKeyPair(certFile string, keyFile string, password string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, "reading TLS certificate %v failed", certFile)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, "reading TLS key %v failed", keyFile)
+	}
+
+	if password != "" {
+		for rest := keyPEM; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if !x509.IsEncryptedPEMBlock(block) {
+				continue
+			}
+			der, err := x509.DecryptPEMBlock(block, []byte(password))
+			if err != nil {
+				return tls.Certificate{}, errors.Wrapf(err, "decrypting TLS key %v failed", keyFile)
+			}
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+			break
+		}
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, "loading TLS key pair %v failed", certFile)
+	}
+	return cert, nil
+}
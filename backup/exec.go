@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/redact"
+)
+
+// Command is an external tool invocation, the arguments are passed
+// as they are to the tool and never interpreted by a shell
+type Command struct {
+	Name string
+	Args []string
+	// Env is appended to the mgob environment
+	Env []string
+	// Timeout kills the tool when exceeded, zero means no timeout
+	Timeout time.Duration
+	// Log receives the stdout and stderr lines, with secrets masked, as they are written
+	Log io.Writer
+}
+
+// CommandResult holds the tool output and exit code
+type CommandResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Output returns stdout and stderr on a single line
+func (r CommandResult) Output() string {
+	out := make([]string, 0, 2)
+	for _, o := range [][]byte{r.Stdout, r.Stderr} {
+		if s := strings.TrimSpace(string(o)); s != "" {
+			out = append(out, strings.Replace(s, "\n", " ", -1))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// Run executes the command and waits for it to exit, a non zero exit code is returned as error
+func (c Command) Run() (CommandResult, error) {
+	res := CommandResult{ExitCode: -1}
+
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Env = append(os.Environ(), c.Env...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return res, errors.Wrapf(err, "%v stdout pipe failed", c.Name)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return res, errors.Wrapf(err, "%v stderr pipe failed", c.Name)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return res, errors.Wrapf(err, "%v start failed", c.Name)
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go c.stream(stdout, &outBuf, &mu, &wg)
+	go c.stream(stderr, &errBuf, &mu, &wg)
+	wg.Wait()
+
+	err = cmd.Wait()
+	res.Stdout = outBuf.Bytes()
+	res.Stderr = errBuf.Bytes()
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return res, errors.Errorf("%v timed out after %v", c.Name, c.Timeout)
+	}
+	if err != nil {
+		return res, errors.Errorf("%v exited with code %v %v", c.Name, res.ExitCode, redact.String(res.Output()))
+	}
+
+	return res, nil
+}
+
+func (c Command) stream(r io.Reader, buf *bytes.Buffer, mu *sync.Mutex, wg *sync.WaitGroup) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		if c.Log != nil {
+			mu.Lock()
+			io.WriteString(c.Log, redact.String(line)+"\n")
+			mu.Unlock()
+		}
+	}
+	// drain whatever is left so the tool never blocks on a full pipe
+	io.Copy(buf, r)
+}
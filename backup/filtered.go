@@ -0,0 +1,207 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+)
+
+// databases managed by the server, never dumped unless listed explicitly
+var systemDatabases = map[string]bool{"admin": true, "local": true, "config": true}
+
+// dumpFiltered dumps every selected database in its own archive, the collections left out by
+// the filters are excluded and the ones with a query are dumped in their own archive.
+// The archives and the manifest are stored in a .tar backup set.
+func dumpFiltered(plan config.Plan, conn config.Connection, mc *MongoClient, host string, dir string, name string, stages Stages, log io.Writer) (string, string, error) {
+	dumps, dbs, err := planDumps(plan, conn, mc, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	setDir := filepath.Join(dir, name)
+	if err := os.MkdirAll(setDir, 0755); err != nil {
+		return "", "", errors.Wrapf(err, "creating dir %v failed", setDir)
+	}
+	defer os.RemoveAll(setDir)
+
+	for _, d := range dumps {
+		args := make([]string, 0)
+		if d.Collection != "" {
+			args = append(args, "--collection", d.Collection)
+		}
+		if d.Query != "" {
+			args = append(args, "--query", d.Query)
+		}
+		for _, c := range d.Excluded {
+			args = append(args, "--excludeCollection", c)
+		}
+		if d.Collection == "" {
+			args = append(args, usersAndRoles(plan, d.Database)...)
+		}
+		dbConn := conn
+		dbConn.Database = d.Database
+		fmt.Fprintf(log, "dumping %v\n", d.Archive)
+		if err := _dump(plan, dbConn, filepath.Join(setDir, d.Archive), host, log, args...); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := dumpMetadata(plan, mc, dbs, filepath.Join(setDir, MetadataName), filepath.Join(dir, name+MetadataExt)); err != nil {
+		return "", "", err
+	}
+
+	manifest := Manifest{
+		Plan:      plan.Name,
+		Name:      name + ".tar",
+		Timestamp: time.Now().UTC(),
+		Topology:  plan.Target.Topology(),
+		Databases: dbs,
+		Dumps:     dumps,
+	}
+	if err := writeManifest(filepath.Join(setDir, ManifestName), manifest); err != nil {
+		return "", "", err
+	}
+	sidecar := filepath.Join(dir, name+".json")
+	if err := writeManifest(sidecar, manifest); err != nil {
+		return "", "", err
+	}
+
+	archive := filepath.Join(dir, manifest.Name)
+	err = stages.time("compress", func() error {
+		return tarDir(setDir, archive)
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return archive, sidecar, nil
+}
+
+// dumpPerDatabase lists the target databases and dumps each one matching the perDatabase patterns
+// in its own archive, a failed database doesn't stop the others
+func dumpPerDatabase(plan config.Plan, conn config.Connection, mc *MongoClient, host string, dir string, ts time.Time, log io.Writer) ([]dbDump, error) {
+	names, err := mc.session.DatabaseNames()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing databases failed")
+	}
+	dbs := make([]dbDump, 0, len(names))
+	for _, db := range names {
+		if plan.Target.Backup.PerDatabase.Matches(db) || (db == "admin" && plan.Target.Backup.Admin) {
+			name := fmt.Sprintf("%v-%v-%v", plan.Name, db, ts.Format("2006-01-02T15:04:05"))
+			dbs = append(dbs, dbDump{
+				Name:     db,
+				Archive:  filepath.Join(dir, name+".gz"),
+				Metadata: filepath.Join(dir, name+MetadataExt),
+			})
+		}
+	}
+	if len(dbs) == 0 {
+		return nil, errors.New("no database matches the perDatabase patterns")
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name < dbs[j].Name })
+
+	for i, d := range dbs {
+		dbConn := conn
+		dbConn.Database = d.Name
+		fmt.Fprintf(log, "dumping %v\n", d.Name)
+		err := dumpMetadata(plan, mc, []string{d.Name}, d.Metadata)
+		if err == nil {
+			err = _dump(plan, dbConn, d.Archive, host, prefixWriter(log, d.Name), usersAndRoles(plan, d.Name)...)
+		}
+		if err != nil {
+			fmt.Fprintf(log, "dumping %v failed %v\n", d.Name, err)
+			os.Remove(d.Archive)
+			os.Remove(d.Metadata)
+			dbs[i].Err = err
+		}
+	}
+
+	return dbs, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// planDumps lists the mongodump runs needed to apply the plan filters
+func planDumps(plan config.Plan, conn config.Connection, mc *MongoClient, now time.Time) ([]Dump, []string, error) {
+	filters := plan.Target.Backup.Collections
+	var queries map[string]config.Query
+	if filters != nil {
+		queries = filters.Queries
+	}
+
+	dbs := plan.Target.Backup.Databases
+	if len(dbs) == 0 && conn.Database != "" {
+		dbs = []string{conn.Database}
+	}
+	if len(dbs) == 0 {
+		names, err := mc.session.DatabaseNames()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "listing databases failed")
+		}
+		for _, db := range names {
+			if !systemDatabases[db] {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+	sort.Strings(dbs)
+
+	dumps := make([]Dump, 0, len(dbs))
+	for _, db := range dbs {
+		colls, err := mc.session.DB(db).CollectionNames()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "listing the collections of %v failed", db)
+		}
+
+		full := Dump{Archive: db + ".gz", Database: db}
+		remaining := 0
+		for _, coll := range colls {
+			if strings.HasPrefix(coll, "system.") {
+				continue
+			}
+			ns := db + "." + coll
+			if !filters.Matches(ns) {
+				full.Excluded = append(full.Excluded, coll)
+				continue
+			}
+			q, ok := queries[ns]
+			if !ok {
+				remaining++
+				continue
+			}
+			query, err := q.JSON(now)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "invalid query for %v", ns)
+			}
+			full.Excluded = append(full.Excluded, coll)
+			dumps = append(dumps, Dump{Archive: ns + ".gz", Database: db, Collection: coll, Query: query})
+		}
+		if remaining > 0 {
+			dumps = append(dumps, full)
+		}
+	}
+	if len(dumps) == 0 {
+		return nil, nil, errors.New("no collection matches the filters")
+	}
+	// the admin database is dumped as a whole, the filters don't apply
+	if plan.Target.Backup.Admin && !contains(dbs, "admin") {
+		dbs = append(dbs, "admin")
+		dumps = append(dumps, Dump{Archive: "admin.gz", Database: "admin"})
+	}
+
+	return dumps, dbs, nil
+}
@@ -2,146 +2,346 @@ package backup
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
-	sh "github.com/codeskyblue/go-sh"
 	"github.com/pkg/errors"
 	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/redact"
 )
 
-func getURIHost(plan config.Plan, clusterType string) string {
-	var host string
-	if plan.Target.Type == clusterType {
-		host = strings.Join(plan.Target.Backup.Host.Mongos, ",")
+func _dump(plan config.Plan, conn config.Connection, archive, host string, log io.Writer, extra ...string) error {
+	auth, cleanup, err := toolArgs(conn, filepath.Dir(archive))
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--archive=" + archive, "--gzip"}
+	if conn.URI == "" {
+		args = append(args, "--host", host)
+	} else {
+		host = redact.String(conn.URI)
 	}
-	if plan.Target.Type == clusterType {
-		host = strings.Join(plan.Target.Backup.Host.Mongod, ",")
+	if conn.Database != "" {
+		args = append(args, "--db", conn.Database)
 	}
-	if plan.Target.Type == clusterType {
-		host = plan.Target.Backup.Host.Mongod[0]
+	args = append(args, extra...)
+	args = append(args, auth...)
+
+	_, err = Command{
+		Name:    "mongodump",
+		Args:    args,
+		Timeout: time.Duration(plan.Scheduler.Timeout) * time.Minute,
+		Log:     log,
+	}.Run()
+	if err != nil {
+		return errors.Wrapf(err, "mongodump %v failed", host)
 	}
-	return host
+	return nil
+}
+
+// dumpInfo describes a finished dump
+type dumpInfo struct {
+	Archive string
+	Log     string
+	// Member is the replica set member the dump was taken from
+	Member string
+	// Manifest is the backup set manifest sidecar, if any
+	Manifest string
+	// Metadata is the server and schema metadata stored next to the archive
+	Metadata string
+	// Databases holds the archives of a perDatabase plan
+	Databases []dbDump
+	// Stages holds the duration of the backup set packing
+	Stages Stages
+}
+
+// dbDump is the archive of a single database, Err is set when its dump failed
+type dbDump struct {
+	Name     string
+	Archive  string
+	Metadata string
+	Err      error
 }
 
-func _dump(plan config.Plan, archive, host string) ([]byte, error) {
-	dump := fmt.Sprintf("mongodump --archive=%v --gzip --host %v ", archive, host)
-	if plan.Target.Backup.Database != "" {
-		dump += fmt.Sprintf("--db %v ", plan.Target.Backup.Database)
+// usersAndRoles returns the mongodump flag dumping the users and roles of db, if enabled
+func usersAndRoles(plan config.Plan, db string) []string {
+	// the admin database users are dumped with the admin database itself
+	if !plan.Target.Backup.UsersAndRoles || db == "" || db == "admin" {
+		return nil
 	}
-	if plan.Target.Backup.Username != "" && plan.Target.Backup.Password != "" {
-		dump += fmt.Sprintf("-u %v -p %v", plan.Target.Backup.Username, plan.Target.Backup.Password)
+	return []string{"--dumpDbUsersAndRoles"}
+}
+
+func dump(plan config.Plan, tmpPath string, ts time.Time) (info dumpInfo, err error) {
+	name := fmt.Sprintf("%v-%v", plan.Name, ts.Format("2006-01-02T15:04:05"))
+	info = dumpInfo{
+		Archive:  fmt.Sprintf("%v/%v.gz", tmpPath, name),
+		Log:      fmt.Sprintf("%v/%v.log", tmpPath, name),
+		Metadata: fmt.Sprintf("%v/%v%v", tmpPath, name, MetadataExt),
+		Stages:   Stages{},
 	}
-	fmt.Println("COMMAND: ", dump)
-	output, err := sh.Command("/bin/sh", "-c", dump).SetTimeout(time.Duration(plan.Scheduler.Timeout) * time.Minute).CombinedOutput()
+	archive := info.Archive
+
+	logFile, err := os.Create(info.Log)
 	if err != nil {
-		ex := ""
-		if len(output) > 0 {
-			ex = strings.Replace(string(output), "\n", " ", -1)
-		}
-		return nil, errors.Wrapf(err, "mongodump log %v", ex)
+		return info, errors.Wrapf(err, "creating log %v failed", info.Log)
 	}
-	return output, nil
-}
+	defer logFile.Close()
 
-func dump(plan config.Plan, tmpPath string, ts time.Time) (string, string, error) {
-	archive := fmt.Sprintf("%v/%v-%v.gz", tmpPath, plan.Name, ts.Format("2006-01-02T15:04:05"))
-	log := fmt.Sprintf("%v/%v-%v.log", tmpPath, plan.Name, ts.Format("2006-01-02T15:04:05"))
+	conn := plan.Target.Backup.Conn()
+	topology := plan.Target.Topology()
+	if topology == "sharding" {
+		hosts := conn.Host.Mongos
+		if conn.URI != "" {
+			conn, hosts, err = seeds(conn)
+			if err != nil {
+				return info, err
+			}
+		}
+		var mc *MongoClient
+		mc, err = NewMongoClient(conn, hosts...)
+		if err != nil {
+			return info, err
+		}
+		defer mc.Close()
 
-	if plan.Target.Type == "sharding" {
-		mc := NewMongoClient(plan.Target.Backup.Host.Mongos[0])
-		// stop balancer
-		err := mc.BalancerStop()
+		var restart func() error
+		restart, err = stopBalancer(plan, plan.Target.Backup.Balancer, mc, logFile)
 		if err != nil {
-			return "", "", errors.Wrapf(err, "failed stoping the mongos balancer")
+			return info, errors.Wrapf(err, "failed stoping the mongos balancer")
 		}
+		// restart the balancer even if the dump fails or panics
+		defer func() {
+			if rerr := restart(); rerr != nil {
+				if err == nil {
+					err = errors.Wrapf(rerr, "failed starting the mongos balancer")
+				} else {
+					err = errors.Wrapf(err, "failed starting the mongos balancer %v", rerr)
+				}
+			}
+		}()
 
-		// backup mongoc
-		for _, host := range plan.Target.Backup.Host.Mongoc {
-			output, err := _dump(plan, archive, host)
+		if plan.Target.Backup.Consistent {
+			info.Archive, info.Manifest, err = dumpCluster(plan, conn, mc, tmpPath, name, info.Stages, logFile)
 			if err != nil {
-				return "", "", errors.Wrapf(err, "mongodump failed")
+				return info, errors.Wrapf(err, "cluster dump failed")
 			}
-			logToFile(log, output)
+			return info, nil
+		}
+
+		if err := dumpMetadata(plan, mc, databases(conn.Database), info.Metadata); err != nil {
+			return info, err
 		}
+		// the mongos routers read every shard, the users and roles come from the config servers
+		if err := _dump(plan, conn, archive, strings.Join(hosts, ","), logFile, usersAndRoles(plan, conn.Database)...); err != nil {
+			return info, errors.Wrapf(err, "mongodump failed")
+		}
+		return info, nil
+	}
 
-		// backup shards (mongod)
-		for _, host := range plan.Target.Backup.Host.Mongod {
-			output, err := _dump(plan, archive, host)
+	host := ""
+	switch {
+	case topology == "replicaset":
+		hosts := conn.Host.Mongod
+		if conn.URI != "" {
+			conn, hosts, err = seeds(conn)
 			if err != nil {
-				return "", "", errors.Wrapf(err, "mongodump failed")
+				return info, err
 			}
-			logToFile(log, output)
 		}
-
-		// start balancer
-		err = mc.BalancerStart()
+		m, err := selectMember(conn, plan.Target.Backup.Member, hosts...)
 		if err != nil {
-			return "", "", errors.Wrapf(err, "failed starting the mongos balancer")
+			return info, errors.Wrapf(err, "replica set member selection failed")
 		}
+		fmt.Fprintf(logFile, "dumping from %v\n", m)
+		info.Member = m.Host
 
-		// check if started
-		err = mc.BalancerStatus()
-		if err != nil {
-			return "", "", errors.Wrapf(err, "failed checking the mongos balancer")
+		// connect directly to the selected member
+		conn.URI = ""
+		conn.ReplicaSet = ""
+		host = m.Host
+	case conn.URI != "":
+		// mongodump connects with the URI
+	case topology == "standalone":
+		host = conn.Host.Mongod[0]
+	default:
+		return info, errors.New("target type not compatible")
+	}
+
+	// a plain dump only needs mgo for the metadata
+	plain := plan.Target.Backup.PerDatabase == nil && !plan.Target.Backup.Filtered() && !plan.Target.Backup.Admin
+	if err := mgoAuth(conn); err != nil && plain {
+		fmt.Fprintf(logFile, "metadata skipped, %v\n", err)
+		info.Metadata = ""
+		if err := _dump(plan, conn, archive, host, logFile, usersAndRoles(plan, conn.Database)...); err != nil {
+			return info, errors.Wrapf(err, "mongodump failed")
 		}
-	} else if plan.Target.Type == "replicaset" {
-		for _, host := range plan.Target.Backup.Host.Mongod {
-			output, err := _dump(plan, archive, host)
-			if err != nil {
-				return "", "", errors.Wrapf(err, "mongodump failed")
-			}
-			logToFile(log, output)
+		return info, nil
+	}
+
+	mc, err := dialTarget(conn, host)
+	if err != nil {
+		return info, err
+	}
+	defer mc.Close()
+
+	if plan.Target.Backup.PerDatabase != nil {
+		info.Metadata = ""
+		info.Databases, err = dumpPerDatabase(plan, conn, mc, host, tmpPath, ts, logFile)
+		if err != nil {
+			return info, errors.Wrapf(err, "per database dump failed")
 		}
-	} else if plan.Target.Type == "standalone" {
-		host := plan.Target.Type
-		output, err := _dump(plan, archive, host)
+		return info, nil
+	}
+
+	if plan.Target.Backup.Filtered() || plan.Target.Backup.Admin {
+		info.Archive, info.Manifest, err = dumpFiltered(plan, conn, mc, host, tmpPath, name, info.Stages, logFile)
 		if err != nil {
-			return "", "", errors.Wrapf(err, "mongodump failed")
+			return info, errors.Wrapf(err, "filtered dump failed")
 		}
-		logToFile(log, output)
-	} else {
-		return "", "", errors.New("target type not compatible")
+		return info, nil
 	}
 
-	return archive, log, nil
+	if err := dumpMetadata(plan, mc, databases(conn.Database), info.Metadata); err != nil {
+		return info, err
+	}
+	if err := _dump(plan, conn, archive, host, logFile, usersAndRoles(plan, conn.Database)...); err != nil {
+		return info, errors.Wrapf(err, "mongodump failed")
+	}
+
+	return info, nil
+}
+
+// databases returns db as a list, empty when every database is dumped
+func databases(db string) []string {
+	if db == "" {
+		return nil
+	}
+	return []string{db}
 }
 
-func logToFile(file string, data []byte) error {
-	if len(data) > 0 {
-		err := ioutil.WriteFile(file, data, 0644)
+// applyRetention keeps the newest retention archives and logs in path and returns the removed files
+func applyRetention(path string, retention int) ([]string, error) {
+	removed := make([]string, 0)
+	for _, ext := range []string{".gz", ".tar", ".json", MetadataExt, ".log"} {
+		files, err := filesByAge(path, ext)
 		if err != nil {
-			return errors.Wrapf(err, "writing log %v failed", file)
+			return removed, err
+		}
+		if len(files) <= retention {
+			continue
+		}
+		for _, f := range files[retention:] {
+			if err := os.Remove(filepath.Join(path, f.Name())); err != nil {
+				return removed, errors.Wrapf(err, "removing old %v files from %v failed", ext, path)
+			}
+			removed = append(removed, f.Name())
 		}
 	}
 
-	return nil
+	return removed, nil
 }
 
-func applyRetention(path string, retention int) error {
-	gz := fmt.Sprintf("cd %v && rm -f $(ls -1t *.gz | tail -n +%v)", path, retention+1)
-	err := sh.Command("/bin/sh", "-c", gz).Run()
+// filesByAge lists the files with the given extension, newest first
+func filesByAge(path string, ext string) ([]os.FileInfo, error) {
+	list, err := ioutil.ReadDir(path)
 	if err != nil {
-		return errors.Wrapf(err, "removing old gz files from %v failed", path)
+		return nil, errors.Wrapf(err, "reading %v failed", path)
 	}
 
-	log := fmt.Sprintf("cd %v && rm -f $(ls -1t *.log | tail -n +%v)", path, retention+1)
-	err = sh.Command("/bin/sh", "-c", log).Run()
-	if err != nil {
-		return errors.Wrapf(err, "removing old log files from %v failed", path)
+	files := make([]os.FileInfo, 0, len(list))
+	for _, f := range list {
+		if f.Mode().IsRegular() && fileExt(f.Name()) == ext {
+			files = append(files, f)
+		}
 	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
 
-	return nil
+	return files, nil
+}
+
+// fileExt is the file extension, the metadata files have a compound one
+func fileExt(name string) string {
+	if strings.HasSuffix(name, MetadataExt) {
+		return MetadataExt
+	}
+	return filepath.Ext(name)
 }
 
 // TmpCleanup remove files older than one day
 func TmpCleanup(path string) error {
-	rm := fmt.Sprintf("find %v -mtime +%v -type f -delete", path, 1)
-	err := sh.Command("/bin/sh", "-c", rm).Run()
+	cutoff := time.Now().Add(-24 * time.Hour)
+	err := filepath.Walk(path, func(file string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.Mode().IsRegular() && f.ModTime().Before(cutoff) {
+			return os.Remove(file)
+		}
+		return nil
+	})
 	if err != nil {
 		return errors.Wrapf(err, "%v cleanup failed", path)
 	}
 
 	return nil
 }
+
+// moveFile renames src to the dst dir, when they are on different
+// devices the file is copied and the source removed
+func moveFile(src string, dstDir string) error {
+	_, name := filepath.Split(src)
+	dst := filepath.Join(dstDir, name)
+
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+		return errors.Wrapf(err, "moving file from %v to %v failed", src, dstDir)
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		os.Remove(dst)
+		return errors.Wrapf(err, "moving file from %v to %v failed", src, dstDir)
+	}
+
+	return os.Remove(src)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ManifestName is the manifest file name inside a backup set
+const ManifestName = "manifest.json"
+
+// Manifest describes a backup set, it's stored inside the set and next to it as a .json sidecar
+type Manifest struct {
+	Plan      string    `json:"plan"`
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	Topology  string    `json:"topology"`
+	// ClusterTime is the point in time every component can be restored to
+	ClusterTime *Timestamp  `json:"clusterTime,omitempty"`
+	Components  []Component `json:"components,omitempty"`
+	Databases   []string    `json:"databases,omitempty"`
+	Dumps       []Dump      `json:"dumps,omitempty"`
+}
+
+// Dump is a single mongodump archive of a database, or of a collection when filtered by a query
+type Dump struct {
+	Archive    string `json:"archive"`
+	Database   string `json:"database"`
+	Collection string `json:"collection,omitempty"`
+	// Excluded lists the collections left out by the filters or dumped on their own
+	Excluded []string `json:"excluded,omitempty"`
+	Query    string   `json:"query,omitempty"`
+}
+
+// Component is the dump of a single shard or of the config servers
+type Component struct {
+	Name string `json:"name"`
+	// Type is shard or config
+	Type    string `json:"type"`
+	Host    string `json:"host"`
+	Archive string `json:"archive"`
+	// OplogFile holds the oplog entries from OplogStart to the cluster time
+	OplogFile  string    `json:"oplogFile,omitempty"`
+	OplogStart Timestamp `json:"oplogStart"`
+	OplogEnd   Timestamp `json:"oplogEnd"`
+}
+
+// Timestamp is a MongoDB oplog timestamp
+type Timestamp struct {
+	T uint32 `json:"t"`
+	I uint32 `json:"i"`
+}
+
+func newTimestamp(ts bson.MongoTimestamp) Timestamp {
+	return Timestamp{T: uint32(int64(ts) >> 32), I: uint32(int64(ts))}
+}
+
+// MongoTimestamp returns the BSON representation
+func (t Timestamp) MongoTimestamp() bson.MongoTimestamp {
+	return bson.MongoTimestamp(int64(t.T)<<32 | int64(t.I))
+}
+
+// After reports whether t is later than o
+func (t Timestamp) After(o Timestamp) bool {
+	return t.T > o.T || (t.T == o.T && t.I > o.I)
+}
+
+// String returns the t:i form used by mongorestore --oplogLimit
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%v:%v", t.T, t.I)
+}
+
+// ParseTimestamp parses the t:i form
+func ParseTimestamp(value string) (Timestamp, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return Timestamp{}, errors.Errorf("invalid timestamp %q, the format is seconds:increment", value)
+	}
+	t, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return Timestamp{}, errors.Errorf("invalid timestamp %q, the format is seconds:increment", value)
+	}
+	i, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return Timestamp{}, errors.Errorf("invalid timestamp %q, the format is seconds:increment", value)
+	}
+	return Timestamp{T: uint32(t), I: uint32(i)}, nil
+}
+
+func writeManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling manifest failed")
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "writing manifest %v failed", path)
+	}
+	return nil
+}
+
+// ReadManifest loads a manifest sidecar file
+func ReadManifest(path string) (Manifest, error) {
+	var m Manifest
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m, errors.Wrapf(err, "reading manifest %v failed", path)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, errors.Wrapf(err, "parsing manifest %v failed", path)
+	}
+	return m, nil
+}
@@ -0,0 +1,189 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrSkipped is returned when the backup is intentionally not taken
+var ErrSkipped = errors.New("backup skipped")
+
+// replica set member states https://docs.mongodb.com/manual/reference/replica-states/
+const (
+	statePrimary   = 1
+	stateSecondary = 2
+)
+
+type replSetStatus struct {
+	Set     string         `bson:"set"`
+	Members []memberStatus `bson:"members"`
+}
+
+type memberStatus struct {
+	Name       string    `bson:"name"`
+	Health     float64   `bson:"health"`
+	State      int       `bson:"state"`
+	StateStr   string    `bson:"stateStr"`
+	OptimeDate time.Time `bson:"optimeDate"`
+}
+
+type replSetConfig struct {
+	Config struct {
+		Members []memberConfig `bson:"members"`
+	} `bson:"config"`
+}
+
+type memberConfig struct {
+	Host               string            `bson:"host"`
+	ArbiterOnly        bool              `bson:"arbiterOnly"`
+	Hidden             bool              `bson:"hidden"`
+	SlaveDelay         int64             `bson:"slaveDelay"`
+	SecondaryDelaySecs int64             `bson:"secondaryDelaySecs"`
+	Tags               map[string]string `bson:"tags"`
+}
+
+// member is a replica set member a dump can be taken from
+type member struct {
+	Host   string
+	State  string
+	Hidden bool
+	Lag    time.Duration
+}
+
+func (m member) String() string {
+	if m.Hidden {
+		return fmt.Sprintf("%v (%v hidden, lag %v)", m.Host, m.State, m.Lag)
+	}
+	return fmt.Sprintf("%v (%v, lag %v)", m.Host, m.State, m.Lag)
+}
+
+// ReplSetStatus returns the replSetGetStatus output
+func (m *MongoClient) ReplSetStatus() (replSetStatus, error) {
+	var result replSetStatus
+	if err := m.session.Run(bson.M{"replSetGetStatus": 1}, &result); err != nil {
+		return result, errors.Wrap(err, "replSetGetStatus failed")
+	}
+	return result, nil
+}
+
+// ReplSetConfig returns the replSetGetConfig output
+func (m *MongoClient) ReplSetConfig() (replSetConfig, error) {
+	var result replSetConfig
+	if err := m.session.Run(bson.M{"replSetGetConfig": 1}, &result); err != nil {
+		return result, errors.Wrap(err, "replSetGetConfig failed")
+	}
+	return result, nil
+}
+
+// selectMember picks the member to dump from, a healthy secondary matching the tags,
+// hidden members first then the lowest lag. Delayed members and arbiters are excluded.
+func selectMember(conn config.Connection, pref *config.Member, hosts ...string) (member, error) {
+	if pref == nil {
+		pref = &config.Member{}
+	}
+
+	mc, err := NewMongoClient(conn, hosts...)
+	if err != nil {
+		return member{}, err
+	}
+	defer mc.Close()
+	// the status and config can be read from any member
+	mc.session.SetMode(mgo.PrimaryPreferred, true)
+
+	status, err := mc.ReplSetStatus()
+	if err != nil {
+		return member{}, err
+	}
+	cfg, err := mc.ReplSetConfig()
+	if err != nil {
+		return member{}, err
+	}
+
+	return pickMember(status, cfg, *pref)
+}
+
+func pickMember(status replSetStatus, cfg replSetConfig, pref config.Member) (member, error) {
+	configs := make(map[string]memberConfig, len(cfg.Config.Members))
+	for _, c := range cfg.Config.Members {
+		configs[c.Host] = c
+	}
+
+	// the lag is measured against the primary, or the most recent member when there's none
+	var newest time.Time
+	var primary *member
+	for _, s := range status.Members {
+		if s.State == statePrimary {
+			newest = s.OptimeDate
+			primary = &member{Host: s.Name, State: s.StateStr}
+			break
+		}
+		if s.OptimeDate.After(newest) {
+			newest = s.OptimeDate
+		}
+	}
+
+	maxLag := time.Duration(pref.MaxLag) * time.Second
+	eligible := make([]member, 0)
+	lagging := make([]member, 0)
+	for _, s := range status.Members {
+		if s.State != stateSecondary || s.Health != 1 {
+			continue
+		}
+		c := configs[s.Name]
+		if c.ArbiterOnly || c.SlaveDelay > 0 || c.SecondaryDelaySecs > 0 || !hasTags(c.Tags, pref.Tags) {
+			continue
+		}
+		m := member{Host: s.Name, State: s.StateStr, Hidden: c.Hidden, Lag: newest.Sub(s.OptimeDate)}
+		if m.Lag < 0 {
+			m.Lag = 0
+		}
+		if maxLag > 0 && m.Lag > maxLag {
+			lagging = append(lagging, m)
+			continue
+		}
+		eligible = append(eligible, m)
+	}
+
+	sort.Slice(eligible, func(i, j int) bool {
+		if eligible[i].Hidden != eligible[j].Hidden {
+			return eligible[i].Hidden
+		}
+		if eligible[i].Lag != eligible[j].Lag {
+			return eligible[i].Lag < eligible[j].Lag
+		}
+		return eligible[i].Host < eligible[j].Host
+	})
+
+	if len(eligible) > 0 {
+		return eligible[0], nil
+	}
+	if pref.AllowPrimary && primary != nil {
+		return *primary, nil
+	}
+	if len(lagging) > 0 {
+		sort.Slice(lagging, func(i, j int) bool { return lagging[i].Lag < lagging[j].Lag })
+		err := errors.Errorf("every secondary of %v exceeds the max lag of %v, the least lagging is %v",
+			status.Set, maxLag, lagging[0])
+		if pref.OnLag == config.OnLagSkip {
+			return member{}, errors.Wrap(ErrSkipped, err.Error())
+		}
+		return member{}, err
+	}
+
+	return member{}, errors.Errorf("no healthy secondary found in %v", status.Set)
+}
+
+func hasTags(tags map[string]string, required map[string]string) bool {
+	for k, v := range required {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,264 @@
+package backup
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MetadataName is the metadata file name inside a backup set
+const MetadataName = "metadata.json"
+
+// MetadataExt is the extension of the metadata file stored next to an archive
+const MetadataExt = ".meta.json"
+
+// Metadata describes the server and the schema a backup was taken from
+type Metadata struct {
+	Timestamp time.Time `json:"timestamp"`
+	Version   string    `json:"version"`
+	// FeatureCompatibilityVersion is empty when the server doesn't report it
+	FeatureCompatibilityVersion string             `json:"featureCompatibilityVersion,omitempty"`
+	Topology                    string             `json:"topology"`
+	Shards                      []ShardMetadata    `json:"shards,omitempty"`
+	Databases                   []DatabaseMetadata `json:"databases"`
+}
+
+// ShardMetadata is a shard or the config server replica set of a sharded cluster
+type ShardMetadata struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Host string `json:"host"`
+}
+
+// DatabaseMetadata lists the collections of a dumped database
+type DatabaseMetadata struct {
+	Name        string               `json:"name"`
+	Collections []CollectionMetadata `json:"collections"`
+}
+
+// CollectionMetadata holds the collection options and index definitions as returned by the server
+type CollectionMetadata struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type,omitempty"`
+	Options bson.M   `json:"options,omitempty"`
+	Indexes []bson.M `json:"indexes,omitempty"`
+}
+
+// collectMetadata reads the server version and the schema of dbs, every database except
+// local and config when dbs is empty
+func collectMetadata(plan config.Plan, mc *MongoClient, dbs []string) (Metadata, error) {
+	meta := Metadata{
+		Timestamp: time.Now().UTC(),
+		Topology:  plan.Target.Topology(),
+	}
+
+	var err error
+	if meta.Version, meta.FeatureCompatibilityVersion, err = mc.serverVersion(); err != nil {
+		return meta, err
+	}
+
+	if meta.Topology == "sharding" {
+		members, err := mc.ListShards()
+		if err != nil {
+			return meta, err
+		}
+		for _, m := range members {
+			meta.Shards = append(meta.Shards, ShardMetadata{Name: m.Name, Type: m.Type, Host: m.host()})
+		}
+	}
+
+	if len(dbs) == 0 {
+		names, err := mc.session.DatabaseNames()
+		if err != nil {
+			return meta, errors.Wrap(err, "listing databases failed")
+		}
+		for _, db := range names {
+			if db != "local" && db != "config" {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+	sort.Strings(dbs)
+
+	for _, db := range dbs {
+		colls, err := mc.collections(db)
+		if err != nil {
+			return meta, err
+		}
+		meta.Databases = append(meta.Databases, DatabaseMetadata{Name: db, Collections: colls})
+	}
+
+	return meta, nil
+}
+
+// serverVersion returns the server version and its featureCompatibilityVersion
+func (m *MongoClient) serverVersion() (string, string, error) {
+	info, err := m.session.BuildInfo()
+	if err != nil {
+		return "", "", errors.Wrap(err, "buildInfo failed")
+	}
+
+	// mongos and old servers don't have the parameter
+	var param struct {
+		FCV struct {
+			Version string `bson:"version"`
+		} `bson:"featureCompatibilityVersion"`
+	}
+	err = m.session.DB("admin").Run(bson.D{{Name: "getParameter", Value: 1}, {Name: "featureCompatibilityVersion", Value: 1}}, &param)
+	if err != nil {
+		return info.Version, "", nil
+	}
+
+	return info.Version, param.FCV.Version, nil
+}
+
+// collections lists the options and the indexes of the collections of db, views have no indexes
+func (m *MongoClient) collections(db string) ([]CollectionMetadata, error) {
+	colls := make([]CollectionMetadata, 0)
+	var c struct {
+		Name    string `bson:"name"`
+		Type    string `bson:"type"`
+		Options bson.M `bson:"options"`
+	}
+	err := m.cursor(db, bson.D{{Name: "listCollections", Value: 1}, {Name: "cursor", Value: bson.M{}}}, &c, func() error {
+		colls = append(colls, CollectionMetadata{Name: c.Name, Type: c.Type, Options: c.Options})
+		c.Type, c.Options = "", nil
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing the collections of %v failed", db)
+	}
+
+	for i := range colls {
+		if colls[i].Type == "view" {
+			continue
+		}
+		var idx bson.M
+		err := m.cursor(db, bson.D{{Name: "listIndexes", Value: colls[i].Name}, {Name: "cursor", Value: bson.M{}}}, &idx, func() error {
+			colls[i].Indexes = append(colls[i].Indexes, idx)
+			idx = nil
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing the indexes of %v.%v failed", db, colls[i].Name)
+		}
+	}
+	sort.Slice(colls, func(i, j int) bool { return colls[i].Name < colls[j].Name })
+
+	return colls, nil
+}
+
+// cursor runs a command returning a cursor and decodes every document into result, the
+// batches after the first one are fetched with getMore
+func (m *MongoClient) cursor(db string, cmd bson.D, result interface{}, fn func() error) error {
+	var res struct {
+		Cursor struct {
+			ID         int64      `bson:"id"`
+			NS         string     `bson:"ns"`
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+	if err := m.session.DB(db).Run(cmd, &res); err != nil {
+		return err
+	}
+
+	coll := m.session.DB(db).C("")
+	if ns := strings.SplitN(res.Cursor.NS, ".", 2); len(ns) == 2 {
+		coll = m.session.DB(ns[0]).C(ns[1])
+	}
+	iter := coll.NewIter(nil, res.Cursor.FirstBatch, res.Cursor.ID, nil)
+	for iter.Next(result) {
+		if err := fn(); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+func writeMetadata(path string, meta Metadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling metadata failed")
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "writing metadata %v failed", path)
+	}
+	return nil
+}
+
+// ReadMetadata loads a metadata file
+func ReadMetadata(path string) (Metadata, error) {
+	var meta Metadata
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return meta, errors.Wrapf(err, "reading metadata %v failed", path)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, errors.Wrapf(err, "parsing metadata %v failed", path)
+	}
+	return meta, nil
+}
+
+// MetadataPath returns the metadata file stored next to archive
+func MetadataPath(archive string) string {
+	return strings.TrimSuffix(archive, fileExt(archive)) + MetadataExt
+}
+
+// checkCompatibility fails when the target server version is older than the one the backup
+// was taken from, or when its featureCompatibilityVersion is lower
+func checkCompatibility(meta Metadata, version string, fcv string) error {
+	if compareVersions(version, meta.Version) < 0 {
+		return errors.Errorf("the backup was taken from MongoDB %v, it can't be restored to the older %v", meta.Version, version)
+	}
+	if fcv != "" && meta.FeatureCompatibilityVersion != "" && compareVersions(fcv, meta.FeatureCompatibilityVersion) < 0 {
+		return errors.Errorf("the backup featureCompatibilityVersion is %v, the target one is %v", meta.FeatureCompatibilityVersion, fcv)
+	}
+	return nil
+}
+
+// compareVersions compares the major and minor numbers of two versions
+func compareVersions(a string, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := range pa {
+		switch {
+		case pa[i] < pb[i]:
+			return -1
+		case pa[i] > pb[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) [2]int {
+	var parts [2]int
+	for i, s := range strings.SplitN(v, ".", 3) {
+		if i > 1 {
+			break
+		}
+		parts[i], _ = strconv.Atoi(s)
+	}
+	return parts
+}
+
+// dumpMetadata collects the metadata of dbs and writes it to every path
+func dumpMetadata(plan config.Plan, mc *MongoClient, dbs []string, paths ...string) error {
+	meta, err := collectMetadata(plan, mc, dbs)
+	if err != nil {
+		return errors.Wrap(err, "collecting metadata failed")
+	}
+	for _, path := range paths {
+		if err := writeMetadata(path, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package backup
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"3.6.2", "3.6.2", 0},
+		{"3.6.2", "3.6.8", 0},
+		{"3.6", "3.6.8", 0},
+		{"3.4.10", "3.6.2", -1},
+		{"4.0.0", "3.6.2", 1},
+		{"10.0", "9.2", 1},
+		{"3.10", "3.9", 1},
+		{"4.0.0-rc1", "4.0.1", 0},
+		{"", "3.6", -1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	meta := Metadata{Version: "3.6.2", FeatureCompatibilityVersion: "3.6"}
+
+	tests := []struct {
+		name    string
+		meta    Metadata
+		version string
+		fcv     string
+		ok      bool
+	}{
+		{name: "same version", meta: meta, version: "3.6.2", fcv: "3.6", ok: true},
+		{name: "newer patch", meta: meta, version: "3.6.8", fcv: "3.6", ok: true},
+		{name: "newer version", meta: meta, version: "4.0.0", fcv: "4.0", ok: true},
+		{name: "older version", meta: meta, version: "3.4.10", fcv: "3.4", ok: false},
+		{name: "lower fcv", meta: meta, version: "4.0.0", fcv: "3.4", ok: false},
+		{name: "target without fcv", meta: meta, version: "4.0.0", fcv: "", ok: true},
+		{name: "backup without fcv", meta: Metadata{Version: "3.6.2"}, version: "3.6.2", fcv: "3.4", ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCompatibility(tt.meta, tt.version, tt.fcv)
+			if (err == nil) != tt.ok {
+				t.Errorf("checkCompatibility() error %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
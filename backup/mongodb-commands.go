@@ -2,9 +2,10 @@ package backup
 
 import (
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
 	"gopkg.in/mgo.v2/bson"
 
 	mgo "gopkg.in/mgo.v2"
@@ -16,54 +17,93 @@ type MongoClient struct {
 	session *mgo.Session
 }
 
-func NewMongoClient(host string) *MongoClient {
-	sess, err := mgo.Dial(host)
+func NewMongoClient(conn config.Connection, hosts ...string) (*MongoClient, error) {
+	info, err := dialInfo(conn, hosts...)
 	if err != nil {
-		log.Fatalf("unable to connect to mongodb %v: %v", host, err)
+		return nil, errors.Wrapf(err, "unable to connect to mongodb %v", hosts)
+	}
+	sess, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to mongodb %v", hosts)
 	}
 
-	return &MongoClient{session: sess}
+	return &MongoClient{session: sess}, nil
 }
 
-func (m *MongoClient) BalancerStart() error {
-	var result string
-	err := m.session.Run(bson.M{"balancerStart": 1}, &result)
+// dialTarget connects to the dumped server, a host or the URI seeds, and allows reads from secondaries
+func dialTarget(conn config.Connection, host string) (*MongoClient, error) {
+	hosts := []string{host}
+	if conn.URI != "" {
+		var err error
+		if conn, hosts, err = seeds(conn); err != nil {
+			return nil, err
+		}
+	}
+	mc, err := NewMongoClient(conn, hosts...)
 	if err != nil {
-		log.Fatalf("unable to start the mongos balancer: %v\n", err)
+		return nil, err
+	}
+	mc.session.SetMode(mgo.Monotonic, true)
+	return mc, nil
+}
+
+// Close ends the session
+func (m *MongoClient) Close() {
+	m.session.Close()
+}
+
+func (m *MongoClient) BalancerStart() error {
+	var result bson.M
+	if err := m.session.Run(bson.M{"balancerStart": 1}, &result); err != nil {
+		return errors.Wrap(err, "unable to start the mongos balancer")
 	}
 
-	log.Printf(result)
 	return nil
 }
 
 func (m *MongoClient) BalancerStop() error {
-	var result string
-	err := m.session.Run(bson.M{"balancerStop": 1}, &result)
-	if err != nil {
-		errors.Wrapf(err, "unable to stop the mongos balancer")
+	var result bson.M
+	if err := m.session.Run(bson.M{"balancerStop": 1}, &result); err != nil {
+		return errors.Wrap(err, "unable to stop the mongos balancer")
 	}
 
-	log.Printf(result)
 	return nil
 }
 
-type resultBalancerStatus struct {
-	mode              string `bson:"mode"`
-	inBalancerRound   bool   `bson:"inBalancerRound"`
-	numBalancerRounds int    `bson:"numBalancerRounds"`
+// BalancerStatus is the balancerStatus command output
+type BalancerStatus struct {
+	Mode              string `bson:"mode"`
+	InBalancerRound   bool   `bson:"inBalancerRound"`
+	NumBalancerRounds int64  `bson:"numBalancerRounds"`
 }
 
-func (r resultBalancerStatus) String() string {
-	return fmt.Sprintf("mode: %v\n, inBalancerRound: %v\n, numBalancerRounds: %v\n", r.mode, r.inBalancerRound, r.numBalancerRounds)
+func (r BalancerStatus) String() string {
+	return fmt.Sprintf("mode: %v, inBalancerRound: %v, numBalancerRounds: %v", r.Mode, r.InBalancerRound, r.NumBalancerRounds)
 }
 
-func (m *MongoClient) BalancerStatus() error {
-	var result resultBalancerStatus
-	err := m.session.Run(bson.M{"balancerStatus": 1}, &result)
-	if err != nil {
-		errors.Wrapf(err, "unable to get the status of mongos balancer")
+func (m *MongoClient) BalancerStatus() (BalancerStatus, error) {
+	var result BalancerStatus
+	if err := m.session.Run(bson.M{"balancerStatus": 1}, &result); err != nil {
+		return result, errors.Wrap(err, "unable to get the status of mongos balancer")
 	}
 
-	log.Printf(result.String())
-	return nil
+	return result, nil
+}
+
+// WaitBalancerIdle polls the balancer until the running round, if any, is finished
+func (m *MongoClient) WaitBalancerIdle(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := m.BalancerStatus()
+		if err != nil {
+			return err
+		}
+		if !status.InBalancerRound {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("balancer round still running after %v", timeout)
+		}
+		time.Sleep(2 * time.Second)
+	}
 }
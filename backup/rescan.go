@@ -0,0 +1,347 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+	"github.com/vtomasr5/mgob/redact"
+)
+
+// archiveLayout is the timestamp format of the archive names
+const archiveLayout = "2006-01-02T15:04:05"
+
+// remoteFile is a file listed in a remote destination
+type remoteFile struct {
+	Name    string
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// archiveName holds the fields encoded in an archive name,
+// <plan>-<timestamp>, <plan>-<db>-<timestamp> or the legacy <plan>-<unix time>
+type archiveName struct {
+	Plan      string
+	Database  string
+	Timestamp time.Time
+}
+
+// parseArchiveName parses the name of a .gz or .tar archive, the longest matching plan wins
+// so the archives of plan a-b are not taken for archives of plan a
+func parseArchiveName(name string, plans []string) (archiveName, bool) {
+	ext := filepath.Ext(name)
+	if ext != ".gz" && ext != ".tar" {
+		return archiveName{}, false
+	}
+	base := strings.TrimSuffix(name, ext)
+
+	var best archiveName
+	found := false
+	for _, plan := range plans {
+		if !strings.HasPrefix(base, plan+"-") || (found && len(plan) <= len(best.Plan)) {
+			continue
+		}
+		rest := base[len(plan)+1:]
+		a := archiveName{Plan: plan}
+		if len(rest) >= len(archiveLayout) {
+			ts, err := time.Parse(archiveLayout, rest[len(rest)-len(archiveLayout):])
+			prefix := rest[:len(rest)-len(archiveLayout)]
+			if err == nil && (prefix == "" || (len(prefix) > 1 && strings.HasSuffix(prefix, "-"))) {
+				a.Database = strings.TrimSuffix(prefix, "-")
+				a.Timestamp = ts
+				best, found = a, true
+				continue
+			}
+		}
+		if unix, err := strconv.ParseInt(rest, 10, 64); err == nil {
+			a.Timestamp = time.Unix(unix, 0).UTC()
+			best, found = a, true
+		}
+	}
+
+	return best, found
+}
+
+// RescanReport is the outcome of a catalog rescan, one entry per plan
+type RescanReport struct {
+	Plans []PlanRescan `json:"plans"`
+}
+
+// PlanRescan lists the changes made to the catalog records of a plan and the copies out of sync
+type PlanRescan struct {
+	Plan     string `json:"plan"`
+	Archives int    `json:"archives"`
+	Added    int    `json:"added"`
+	Updated  int    `json:"updated"`
+	Removed  int    `json:"removed"`
+	// Orphans are the archives stored remotely without a local copy
+	Orphans []string `json:"orphans,omitempty"`
+	// Missing are the archives absent from some of the plan remote destinations
+	Missing []MissingCopy `json:"missing,omitempty"`
+	// Errors holds the destinations that couldn't be listed, their records are left untouched
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// MissingCopy is an archive absent from some remote destinations
+type MissingCopy struct {
+	Archive      string   `json:"archive"`
+	Destinations []string `json:"destinations"`
+}
+
+// Rescan lists the archives stored locally and in every destination of the plans, or of planID
+// only, and merges them into the catalog. The records of the listed destinations are rebuilt,
+// a record left without copies is removed.
+func Rescan(plans []config.Plan, planID string, storagePath string, catalog *db.CatalogStore) (RescanReport, error) {
+	names := make([]string, 0, len(plans))
+	for _, plan := range plans {
+		names = append(names, plan.Name)
+	}
+
+	report := RescanReport{Plans: make([]PlanRescan, 0, len(plans))}
+	for _, plan := range plans {
+		if planID != "" && plan.Name != planID {
+			continue
+		}
+		r, err := rescanPlan(plan, names, storagePath, catalog)
+		if err != nil {
+			return report, errors.Wrapf(err, "rescanning plan %v failed", plan.Name)
+		}
+		report.Plans = append(report.Plans, r)
+	}
+	if planID != "" && len(report.Plans) == 0 {
+		return report, errors.Errorf("plan %v not found", planID)
+	}
+
+	return report, nil
+}
+
+func rescanPlan(plan config.Plan, plans []string, storagePath string, catalog *db.CatalogStore) (PlanRescan, error) {
+	report := PlanRescan{Plan: plan.Name}
+	found := make(map[string]*db.Backup)
+	scanned := map[string]bool{"local": true}
+
+	local, err := scanLocal(plan, storagePath)
+	if err != nil {
+		return report, err
+	}
+	for _, b := range local {
+		found[b.Archive] = b
+	}
+
+	remotes := make(map[string]func(config.Plan) ([]remoteFile, error))
+	if plan.SFTP != nil {
+		remotes["sftp"] = sftpList
+	}
+	if plan.S3 != nil {
+		remotes["s3"] = s3List
+	}
+	for dest, list := range remotes {
+		files, err := list(plan)
+		if err != nil {
+			if report.Errors == nil {
+				report.Errors = make(map[string]string)
+			}
+			report.Errors[dest] = redact.Error(err)
+			continue
+		}
+		scanned[dest] = true
+		for _, f := range files {
+			a, ok := parseArchiveName(f.Name, plans)
+			if !ok || a.Plan != plan.Name {
+				continue
+			}
+			b, ok := found[f.Name]
+			if !ok {
+				b = newScannedBackup(plan.Name, f.Name, a, f.Size, f.ModTime)
+				found[f.Name] = b
+			}
+			b.Copies = append(b.Copies, db.Copy{Destination: dest, Key: f.Key})
+		}
+	}
+
+	for _, b := range found {
+		merged, added, err := mergeBackup(catalog, storagePath, b, scanned)
+		if err != nil {
+			return report, err
+		}
+		if added {
+			report.Added++
+		} else {
+			report.Updated++
+		}
+		if err := catalog.Put(merged); err != nil {
+			return report, err
+		}
+	}
+
+	// the records whose copies are all gone
+	existing, err := catalog.List(db.CatalogFilter{Plan: plan.Name})
+	if err != nil {
+		return report, err
+	}
+	for _, b := range existing {
+		if _, ok := found[b.Archive]; ok {
+			continue
+		}
+		for _, c := range b.Copies {
+			if scanned[c.Destination] {
+				if err := catalog.RemoveCopy(plan.Name, b.Archive, c.Destination); err != nil {
+					return report, err
+				}
+			}
+		}
+		if after, err := catalog.Get(plan.Name, b.Archive); err != nil {
+			return report, err
+		} else if after == nil {
+			report.Removed++
+		}
+	}
+
+	archives := make([]string, 0, len(found))
+	for name := range found {
+		archives = append(archives, name)
+	}
+	sort.Strings(archives)
+	report.Archives = len(archives)
+	for _, name := range archives {
+		b := found[name]
+		if _, ok := b.Copy("local"); !ok {
+			report.Orphans = append(report.Orphans, name)
+		}
+		missing := make([]string, 0)
+		for dest := range remotes {
+			if _, ok := b.Copy(dest); scanned[dest] && !ok {
+				missing = append(missing, dest)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			report.Missing = append(report.Missing, MissingCopy{Archive: name, Destinations: missing})
+		}
+	}
+
+	return report, nil
+}
+
+// scanLocal lists the archives stored in the plan dir and in its per database dirs
+func scanLocal(plan config.Plan, storagePath string) ([]*db.Backup, error) {
+	planDir := filepath.Join(storagePath, plan.Name)
+	dirs := []string{planDir}
+	list, err := ioutil.ReadDir(planDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %v failed", planDir)
+	}
+	for _, f := range list {
+		if f.IsDir() {
+			dirs = append(dirs, filepath.Join(planDir, f.Name()))
+		}
+	}
+
+	backups := make([]*db.Backup, 0)
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %v failed", dir)
+		}
+		for _, f := range files {
+			a, ok := parseArchiveName(f.Name(), []string{plan.Name})
+			if !f.Mode().IsRegular() || !ok {
+				continue
+			}
+			path := filepath.Join(dir, f.Name())
+			rel, err := filepath.Rel(storagePath, path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading %v failed", path)
+			}
+
+			b := newScannedBackup(plan.Name, f.Name(), a, f.Size(), f.ModTime())
+			b.Copies = append(b.Copies, db.Copy{Destination: "local", Key: rel})
+			if meta, err := ReadMetadata(MetadataPath(path)); err == nil {
+				b.ServerVersion = meta.Version
+				for _, d := range meta.Databases {
+					b.Databases = append(b.Databases, d.Name)
+				}
+			} else if manifest, err := ReadManifest(strings.TrimSuffix(path, filepath.Ext(path)) + ".json"); err == nil {
+				b.Databases = manifest.Databases
+			}
+			backups = append(backups, b)
+		}
+	}
+
+	return backups, nil
+}
+
+func newScannedBackup(plan string, archive string, a archiveName, size int64, modTime time.Time) *db.Backup {
+	b := &db.Backup{
+		Plan:       plan,
+		Archive:    archive,
+		Timestamp:  a.Timestamp,
+		Size:       size,
+		Codec:      "gzip",
+		Encryption: "none",
+		Database:   a.Database,
+	}
+	if b.Timestamp.IsZero() {
+		b.Timestamp = modTime.UTC()
+	}
+	return b
+}
+
+// mergeBackup merges the scanned copies into the catalog record, the copies of the
+// destinations that couldn't be listed are kept. A missing checksum is computed from the local copy.
+func mergeBackup(catalog *db.CatalogStore, storagePath string, scanned *db.Backup, listed map[string]bool) (*db.Backup, bool, error) {
+	existing, err := catalog.Get(scanned.Plan, scanned.Archive)
+	if err != nil {
+		return nil, false, err
+	}
+
+	merged := scanned
+	added := existing == nil
+	if !added {
+		merged = existing
+		copies := make([]db.Copy, 0, len(existing.Copies)+len(scanned.Copies))
+		for _, c := range existing.Copies {
+			if !listed[c.Destination] {
+				copies = append(copies, c)
+			}
+		}
+		merged.Copies = append(copies, scanned.Copies...)
+		if len(merged.Databases) == 0 {
+			merged.Databases = scanned.Databases
+		}
+		if merged.ServerVersion == "" {
+			merged.ServerVersion = scanned.ServerVersion
+		}
+		if merged.Size == 0 {
+			merged.Size = scanned.Size
+		}
+		if merged.Timestamp.IsZero() {
+			merged.Timestamp = scanned.Timestamp
+		}
+		if merged.Codec == "" {
+			merged.Codec, merged.Encryption = scanned.Codec, scanned.Encryption
+		}
+		if merged.Database == "" {
+			merged.Database = scanned.Database
+		}
+	}
+
+	if c, ok := merged.Copy("local"); ok && merged.Checksum == "" {
+		if merged.Checksum, err = checksum(filepath.Join(storagePath, c.Key)); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return merged, added, nil
+}
@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseArchiveName(t *testing.T) {
+	ts := time.Date(2018, 1, 10, 6, 0, 0, 0, time.UTC)
+	plans := []string{"mongo", "mongo-prod", "sales"}
+
+	tests := []struct {
+		name string
+		ok   bool
+		want archiveName
+	}{
+		{name: "mongo-2018-01-10T06:00:00.gz", ok: true, want: archiveName{Plan: "mongo", Timestamp: ts}},
+		{name: "mongo-2018-01-10T06:00:00.tar", ok: true, want: archiveName{Plan: "mongo", Timestamp: ts}},
+		{name: "mongo-prod-2018-01-10T06:00:00.gz", ok: true, want: archiveName{Plan: "mongo-prod", Timestamp: ts}},
+		{name: "sales-orders-2018-01-10T06:00:00.gz", ok: true, want: archiveName{Plan: "sales", Database: "orders", Timestamp: ts}},
+		{name: "sales-my-db-2018-01-10T06:00:00.gz", ok: true, want: archiveName{Plan: "sales", Database: "my-db", Timestamp: ts}},
+		{name: "mongo-prod-orders-2018-01-10T06:00:00.gz", ok: true, want: archiveName{Plan: "mongo-prod", Database: "orders", Timestamp: ts}},
+		{name: "mongo-1515564000.gz", ok: true, want: archiveName{Plan: "mongo", Timestamp: ts}},
+		{name: "mongo-2018-01-10T06:00:00.json", ok: false},
+		{name: "mongo-2018-01-10T06:00:00.log", ok: false},
+		{name: "other-2018-01-10T06:00:00.gz", ok: false},
+		{name: "mongo-latest.gz", ok: false},
+		{name: "mongo--2018-01-10T06:00:00.gz", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseArchiveName(tt.name, plans)
+			if ok != tt.ok {
+				t.Fatalf("parseArchiveName(%q) ok %v, want %v", tt.name, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got.Plan != tt.want.Plan || got.Database != tt.want.Database || !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("parseArchiveName(%q) = %+v, want %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -41,7 +41,7 @@ func Restore(plan config.Plan, tmpPath string, storagePath string) (Result, erro
 	}
 
 	if plan.Scheduler.Retention > 0 {
-		err = applyRetention(planDir, plan.Scheduler.Retention)
+		_, err = applyRetention(planDir, plan.Scheduler.Retention)
 		if err != nil {
 			return res, errors.Wrap(err, "retention job failed")
 		}
@@ -0,0 +1,337 @@
+package backup
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// config collections describing the cluster itself, they belong to the target
+var configExcludes = []string{
+	"config.mongos",
+	"config.lockpings",
+	"config.locks",
+	"config.system.sessions",
+	"config.transactions",
+	"config.cache.*",
+}
+
+// shard collections holding the shard identity and routing cache, they belong to the target
+var shardExcludes = []string{
+	"config.*",
+	"admin.system.version",
+}
+
+// RestoreCluster restores a sharded backup set to the plan restore cluster: the config servers
+// first, then every shard to the target shard it's mapped to, each one replayed up to the
+// backup set cluster time. The balancer is stopped during the restore and the target
+// must run the same or a newer MongoDB version than the backup.
+func RestoreCluster(plan config.Plan, archive string, tmpPath string, storagePath string) (Result, error) {
+	t1 := time.Now()
+	_, name := filepath.Split(archive)
+	res := Result{
+		Plan:      plan.Name,
+		Name:      name,
+		Timestamp: t1.UTC(),
+		Status:    500,
+	}
+
+	if plan.Target.Topology() != "sharding" {
+		return res, errors.Errorf("plan %v is not a sharding plan", plan.Name)
+	}
+
+	// the restore logs are kept apart from the backup logs rotated by the retention
+	logDir := filepath.Join(storagePath, plan.Name, "restore")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return res, errors.Wrapf(err, "creating dir %v failed", logDir)
+	}
+	logPath := filepath.Join(logDir, fmt.Sprintf("%v-restore-%v.log", plan.Name, t1.UTC().Format("2006-01-02T15:04:05")))
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return res, errors.Wrapf(err, "creating log %v failed", logPath)
+	}
+	defer logFile.Close()
+
+	setDir, err := ioutil.TempDir(tmpPath, ".mgob-restore-")
+	if err != nil {
+		return res, errors.Wrapf(err, "creating dir in %v failed", tmpPath)
+	}
+	defer os.RemoveAll(setDir)
+
+	if err := untar(archive, setDir); err != nil {
+		return res, err
+	}
+	manifest, err := ReadManifest(filepath.Join(setDir, ManifestName))
+	if err != nil {
+		return res, errors.Wrapf(err, "%v is not a sharded backup set", name)
+	}
+	if manifest.ClusterTime == nil {
+		return res, errors.Errorf("%v has no cluster time", name)
+	}
+
+	conn := plan.Restore.Conn()
+	hosts := conn.Host.Mongos
+	if conn.URI != "" {
+		conn, hosts, err = seeds(conn)
+		if err != nil {
+			return res, err
+		}
+	}
+	mc, err := NewMongoClient(conn, hosts...)
+	if err != nil {
+		return res, err
+	}
+	defer mc.Close()
+
+	// sets taken before the metadata was recorded can't be checked
+	if meta, err := ReadMetadata(filepath.Join(setDir, MetadataName)); err == nil {
+		version, fcv, err := mc.serverVersion()
+		if err != nil {
+			return res, err
+		}
+		if err := checkCompatibility(meta, version, fcv); err != nil {
+			return res, err
+		}
+	} else {
+		fmt.Fprintf(logFile, "skipping the compatibility check, %v\n", err)
+	}
+
+	members, err := mc.ListShards()
+	if err != nil {
+		return res, err
+	}
+	if mongoc := conn.Host.Mongoc; len(mongoc) > 0 {
+		for i, m := range members {
+			if m.Type == "config" {
+				members[i] = parseClusterMember("config", "config", strings.Join(mongoc, ","))
+			}
+		}
+	}
+	targets, err := mapShards(manifest, members, plan.Restore.Shards)
+	if err != nil {
+		return res, err
+	}
+
+	restart, err := stopBalancer(plan, nil, mc, logFile)
+	if err != nil {
+		return res, errors.Wrap(err, "failed stoping the mongos balancer")
+	}
+	defer func() {
+		if rerr := restart(); rerr != nil {
+			logrus.WithField("plan", plan.Name).Errorf("Restarting the balancer failed %v", rerr)
+		}
+	}()
+
+	// the config servers come first, the shards need the restored metadata
+	sort.SliceStable(manifest.Components, func(i, j int) bool {
+		return manifest.Components[i].Type == "config" && manifest.Components[j].Type != "config"
+	})
+	for _, c := range manifest.Components {
+		target := targets[c.Name]
+		fmt.Fprintf(logFile, "restoring %v to %v\n", c.Name, target.host())
+		if err := restoreComponent(plan, conn, c, target, *manifest.ClusterTime, setDir, prefixWriter(logFile, c.Name)); err != nil {
+			return res, errors.Wrapf(err, "restoring %v failed", c.Name)
+		}
+		if c.Type == "config" {
+			if err := rewriteShards(conn, target, members, plan.Restore.Shards); err != nil {
+				return res, errors.Wrap(err, "rewriting the shards metadata failed")
+			}
+		}
+	}
+
+	// the routers must reload the restored metadata
+	var flushed bson.M
+	if err := mc.session.Run(bson.M{"flushRouterConfig": 1}, &flushed); err != nil {
+		return res, errors.Wrap(err, "flushRouterConfig failed")
+	}
+
+	res.Status = 200
+	res.Duration = time.Now().Sub(t1)
+	return res, nil
+}
+
+// mapShards returns the target of every backup set component, the shards must be mapped
+// explicitly unless the target cluster has a shard with the same name
+func mapShards(manifest Manifest, members []clusterMember, mapping map[string]string) (map[string]clusterMember, error) {
+	byName := make(map[string]clusterMember, len(members))
+	for _, m := range members {
+		byName[m.Name] = m
+	}
+
+	targets := make(map[string]clusterMember, len(manifest.Components))
+	unmapped := make([]string, 0)
+	for _, c := range manifest.Components {
+		name := c.Name
+		if c.Type == "shard" && mapping[c.Name] != "" {
+			name = mapping[c.Name]
+		}
+		m, ok := byName[name]
+		if !ok || m.Type != c.Type {
+			unmapped = append(unmapped, c.Name)
+			continue
+		}
+		targets[c.Name] = m
+	}
+	if len(unmapped) > 0 {
+		return nil, errors.Errorf("no target shard for %v, map them in restore.shards", strings.Join(unmapped, ", "))
+	}
+
+	return targets, nil
+}
+
+func restoreComponent(plan config.Plan, conn config.Connection, c Component, target clusterMember, clusterTime Timestamp, dir string, log io.Writer) error {
+	excludes := shardExcludes
+	args := []string{"--archive=" + filepath.Join(dir, c.Archive), "--gzip", "--drop"}
+	if c.Type == "config" {
+		excludes = configExcludes
+		args = append(args, "--nsInclude=config.*")
+	}
+	for _, ns := range excludes {
+		args = append(args, "--nsExclude="+ns)
+	}
+
+	conn.URI = ""
+	conn.ReplicaSet = ""
+	if err := _restore(plan, conn, target.host(), dir, log, args...); err != nil {
+		return err
+	}
+	if c.OplogFile == "" {
+		return nil
+	}
+
+	// --oplogLimit is exclusive, the cluster time entry must be replayed too
+	limit := Timestamp{T: clusterTime.T, I: clusterTime.I + 1}
+	empty, err := ioutil.TempDir(dir, ".oplog-")
+	if err != nil {
+		return errors.Wrapf(err, "creating dir in %v failed", dir)
+	}
+	return _restore(plan, conn, target.host(), dir, log,
+		"--oplogReplay", "--oplogFile="+filepath.Join(dir, c.OplogFile), "--oplogLimit="+limit.String(), empty)
+}
+
+func _restore(plan config.Plan, conn config.Connection, host string, dir string, log io.Writer, extra ...string) error {
+	auth, cleanup, err := toolArgs(conn, dir)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"--host", host}, auth...)
+	args = append(args, extra...)
+	_, err = Command{
+		Name:    "mongorestore",
+		Args:    args,
+		Timeout: time.Duration(plan.Scheduler.Timeout) * time.Minute,
+		Log:     log,
+	}.Run()
+	if err != nil {
+		return errors.Wrapf(err, "mongorestore %v failed", host)
+	}
+	return nil
+}
+
+// rewriteShards replaces the backup shard names and hosts restored to the config servers
+// with the ones of the target cluster
+func rewriteShards(conn config.Connection, cfg clusterMember, members []clusterMember, mapping map[string]string) error {
+	conn.URI = ""
+	conn.ReplicaSet = cfg.ReplicaSet
+	mc, err := NewMongoClient(conn, cfg.Hosts...)
+	if err != nil {
+		return err
+	}
+	defer mc.Close()
+	db := mc.session.DB("config")
+
+	// renamed in two steps so swapped names don't collide
+	renames := make(map[string]string)
+	for src, dst := range mapping {
+		if src != dst {
+			renames[src] = "mgob-restore-" + dst
+		}
+	}
+	for step := 0; step < 2; step++ {
+		for src, tmp := range renames {
+			from, to := src, tmp
+			if step == 1 {
+				from, to = tmp, strings.TrimPrefix(tmp, "mgob-restore-")
+			}
+			if _, err := db.C("chunks").UpdateAll(bson.M{"shard": from}, bson.M{"$set": bson.M{"shard": to}}); err != nil {
+				return errors.Wrapf(err, "renaming shard %v in config.chunks failed", from)
+			}
+			if _, err := db.C("databases").UpdateAll(bson.M{"primary": from}, bson.M{"$set": bson.M{"primary": to}}); err != nil {
+				return errors.Wrapf(err, "renaming shard %v in config.databases failed", from)
+			}
+		}
+	}
+
+	// the shards collection describes the target cluster
+	if _, err := db.C("shards").RemoveAll(nil); err != nil {
+		return errors.Wrap(err, "clearing config.shards failed")
+	}
+	for _, m := range members {
+		if m.Type != "shard" {
+			continue
+		}
+		if err := db.C("shards").Insert(bson.M{"_id": m.Name, "host": m.host(), "state": 1}); err != nil {
+			return errors.Wrapf(err, "inserting shard %v in config.shards failed", m.Name)
+		}
+	}
+
+	return nil
+}
+
+func untar(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return errors.Wrapf(err, "opening %v failed", archive)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reading %v failed", archive)
+		}
+		// backup sets are flat, anything else is ignored
+		name := filepath.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || name != hdr.Name {
+			continue
+		}
+		out, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return errors.Wrapf(err, "extracting %v failed", name)
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return errors.Wrapf(err, "extracting %v failed", name)
+		}
+	}
+}
+
+// LatestBackupSet returns the newest sharded backup set stored in planDir
+func LatestBackupSet(planDir string) (string, error) {
+	files, err := filesByAge(planDir, ".tar")
+	if err != nil {
+		return "", err
+	}
+	if len(files) < 1 {
+		return "", errors.Errorf("no backup set found in %v", planDir)
+	}
+	return filepath.Join(planDir, files[0].Name()), nil
+}
@@ -1,12 +1,101 @@
 package backup
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Result struct {
-	Name      string        `json:"name"`
-	Plan      string        `json:"plan"`
-	Duration  time.Duration `json:"duration"`
-	Size      int64         `json:"size"`
-	Status    int           `json:"status"`
-	Timestamp time.Time     `json:"timestamp"`
+	// RunID is shared by the results of the same run
+	RunID        string        `json:"run_id,omitempty"`
+	Name         string        `json:"name"`
+	Plan         string        `json:"plan"`
+	Duration     time.Duration `json:"duration"`
+	Size         int64         `json:"size"`
+	Status       int           `json:"status"`
+	Timestamp    time.Time     `json:"timestamp"`
+	Member       string        `json:"member,omitempty"`
+	Database     string        `json:"database,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Checksum     string        `json:"checksum,omitempty"`
+	Destinations []Destination `json:"destinations,omitempty"`
+	// Databases holds the per database results of a perDatabase plan
+	Databases []Result `json:"databases,omitempty"`
+	// Metadata is the local metadata file of the archive
+	Metadata string `json:"-"`
+	// Pruned lists the archives removed from the local storage by the retention
+	Pruned []string `json:"-"`
+	// Stages holds the duration of the dump, compress and retention stages, the copies
+	// durations are in the destinations
+	Stages Stages `json:"stages,omitempty"`
+}
+
+// Stages records the duration of the stages of a run
+type Stages map[string]time.Duration
+
+// time runs fn and adds its duration to the stage
+func (s Stages) time(stage string, fn func() error) error {
+	t1 := time.Now()
+	err := fn()
+	s[stage] += time.Now().Sub(t1)
+	return err
+}
+
+// Destination holds the outcome of copying the archive to a single storage
+type Destination struct {
+	Name     string        `json:"name"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Log      string        `json:"log,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Key is where the archive is stored in the destination
+	Key string `json:"key,omitempty"`
+}
+
+// Failed returns the destinations that didn't receive a copy of the archive
+func (r Result) Failed() []Destination {
+	failed := make([]Destination, 0)
+	for _, d := range r.Destinations {
+		if d.Status != 200 {
+			failed = append(failed, d)
+		}
+	}
+	return failed
+}
+
+// Stored reports whether every archive of a successful or partial run landed in at least one
+// destination, a partial run of a perDatabase plan with a failed database isn't stored
+func (r Result) Stored() bool {
+	if r.Status != 200 && r.Status != 206 {
+		return false
+	}
+	for _, db := range r.Databases {
+		if !db.Stored() {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary returns a one line per destination report, prefixed by the database name for perDatabase plans
+func (r Result) Summary() string {
+	lines := make([]string, 0, len(r.Destinations))
+	for _, db := range r.Databases {
+		if db.Error != "" {
+			lines = append(lines, fmt.Sprintf("%v: failed %v", db.Database, db.Error))
+			continue
+		}
+		for _, line := range strings.Split(db.Summary(), "\n") {
+			lines = append(lines, db.Database+" "+line)
+		}
+	}
+	for _, d := range r.Destinations {
+		if d.Status == 200 {
+			lines = append(lines, fmt.Sprintf("%v: ok in %v", d.Name, d.Duration))
+		} else {
+			lines = append(lines, fmt.Sprintf("%v: failed %v", d.Name, d.Error))
+		}
+	}
+	return strings.Join(lines, "\n")
 }
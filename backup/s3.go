@@ -1,40 +1,104 @@
 package backup
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
-	"github.com/codeskyblue/go-sh"
 	"github.com/pkg/errors"
 	"github.com/vtomasr5/mgob/config"
 )
 
 func s3Upload(file string, plan config.Plan) (string, error) {
-	register := fmt.Sprintf("mc config host add %v %v %v %v %v",
-		plan.Name, plan.S3.URL, plan.S3.AccessKey, plan.S3.SecretKey, plan.S3.API)
+	alias, host, err := s3Host(plan)
+	if err != nil {
+		return "", err
+	}
+
+	// the credentials are passed to mc in the environment instead of
+	// the command line, they never show up in ps nor in the mc config file
+	res, err := Command{
+		Name:    "mc",
+		Args:    []string{"--quiet", "cp", file, fmt.Sprintf("%v/%v", alias, plan.S3.Bucket)},
+		Env:     []string{"MC_HOST_" + alias + "=" + host},
+		Timeout: time.Duration(plan.Scheduler.Timeout) * time.Minute,
+	}.Run()
+	output := res.Output()
 
-	_, err := sh.Command("/bin/sh", "-c", register).CombinedOutput()
 	if err != nil {
-		return "", errors.Wrapf(err, "mc config host for plan %v failed", plan.Name)
+		return "", errors.Wrapf(err, "S3 uploading %v to %v/%v failed", file, plan.Name, plan.S3.Bucket)
+	}
+
+	if strings.Contains(output, "<ERROR>") {
+		return "", errors.Errorf("S3 upload failed %v", output)
 	}
 
-	upload := fmt.Sprintf("mc --quiet cp %v %v/%v",
-		file, plan.Name, plan.S3.Bucket)
+	return output, nil
+}
 
-	result, err := sh.Command("/bin/sh", "-c", upload).SetTimeout(time.Duration(plan.Scheduler.Timeout) * time.Minute).CombinedOutput()
-	output := ""
-	if len(result) > 0 {
-		output = strings.Replace(string(result), "\n", " ", -1)
+// s3List lists the objects stored at the root of the bucket
+func s3List(plan config.Plan) ([]remoteFile, error) {
+	alias, host, err := s3Host(plan)
+	if err != nil {
+		return nil, err
 	}
 
+	res, err := Command{
+		Name:    "mc",
+		Args:    []string{"--quiet", "--json", "ls", fmt.Sprintf("%v/%v/", alias, plan.S3.Bucket)},
+		Env:     []string{"MC_HOST_" + alias + "=" + host},
+		Timeout: time.Duration(plan.Scheduler.Timeout) * time.Minute,
+	}.Run()
 	if err != nil {
-		return "", errors.Wrapf(err, "S3 uploading %v to %v/%v failed %v", file, plan.Name, plan.S3.Bucket, output)
+		return nil, errors.Wrapf(err, "S3 listing %v/%v failed", plan.Name, plan.S3.Bucket)
 	}
 
-	if strings.Contains(output, "<ERROR>") {
-		return "", errors.Errorf("S3 upload failed %v", output)
+	// mc prints one json object per line
+	files := make([]remoteFile, 0)
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var obj struct {
+			Status       string    `json:"status"`
+			Type         string    `json:"type"`
+			Key          string    `json:"key"`
+			Size         int64     `json:"size"`
+			LastModified time.Time `json:"lastModified"`
+			Error        struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			return nil, errors.Wrapf(err, "S3 listing %v/%v failed, unexpected output %v", plan.Name, plan.S3.Bucket, line)
+		}
+		if obj.Status == "error" {
+			return nil, errors.Errorf("S3 listing %v/%v failed %v", plan.Name, plan.S3.Bucket, obj.Error.Message)
+		}
+		if obj.Type != "file" {
+			continue
+		}
+		files = append(files, remoteFile{
+			Name:    obj.Key,
+			Key:     plan.S3.Bucket + "/" + obj.Key,
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		})
+	}
+	return files, nil
+}
+
+// s3Host returns the mc alias of the plan and its MC_HOST_<alias> value
+func s3Host(plan config.Plan) (string, string, error) {
+	u, err := url.Parse(plan.S3.URL)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid S3 url for plan %v", plan.Name)
 	}
+	u.User = url.UserPassword(plan.S3.AccessKey, plan.S3.SecretKey)
 
-	return strings.Replace(output, "\n", " ", -1), nil
+	// env var names can't contain dashes
+	alias := strings.Replace(plan.Name, "-", "_", -1)
+	return alias, u.String(), nil
 }
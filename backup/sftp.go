@@ -60,6 +60,7 @@ func sftpUpload(file string, plan config.Plan) (string, error) {
 	if err != nil {
 		return "", errors.Wrapf(err, "SSH dial to %v:%v failed", plan.SFTP.Host, plan.SFTP.Port)
 	}
+	defer sshCon.client.Close()
 	defer sshCon.session.Close()
 
 	sftpClient, err := sftp.NewClient(sshCon.client)
@@ -102,6 +103,7 @@ func sftpDownload(file string, plan config.Plan) (string, error) {
 	if err != nil {
 		return "", errors.Wrapf(err, "SSH dial to %v:%v failed", plan.SFTP.Host, plan.SFTP.Port)
 	}
+	defer sshCon.client.Close()
 	defer sshCon.session.Close()
 
 	sftpClient, err := sftp.NewClient(sshCon.client)
@@ -147,7 +149,7 @@ func newestFile(dir string) (string, error) {
 	for _, f := range files {
 		fi, err := os.Stat(dir + f.Name())
 		if err != nil {
-			return "", errors.Wrapf(err, "Failed to stat '%v", f.Name)
+			return "", errors.Wrapf(err, "Failed to stat '%v", f.Name())
 		}
 		currTime := fi.ModTime().Unix()
 		if currTime > newestTime {
@@ -170,3 +172,36 @@ func listSftpBackups(client *sftp.Client, dir string) error {
 
 	return nil
 }
+
+// sftpList lists the files of the SFTP backup dir
+func sftpList(plan config.Plan) ([]remoteFile, error) {
+	sshCon, err := NewSSHClient(plan)
+	if err != nil {
+		return nil, errors.Wrapf(err, "SSH dial to %v:%v failed", plan.SFTP.Host, plan.SFTP.Port)
+	}
+	defer sshCon.client.Close()
+	defer sshCon.session.Close()
+
+	sftpClient, err := sftp.NewClient(sshCon.client)
+	if err != nil {
+		return nil, errors.Wrapf(err, "SFTP client init %v:%v failed", plan.SFTP.Host, plan.SFTP.Port)
+	}
+	defer sftpClient.Close()
+
+	list, err := sftpClient.ReadDir(plan.SFTP.BackupDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "SFTP %v:%v listing %v failed", plan.SFTP.Host, plan.SFTP.Port, plan.SFTP.BackupDir)
+	}
+	files := make([]remoteFile, 0, len(list))
+	for _, f := range list {
+		if f.Mode().IsRegular() {
+			files = append(files, remoteFile{
+				Name:    f.Name(),
+				Key:     filepath.Join(plan.SFTP.BackupDir, f.Name()),
+				Size:    f.Size(),
+				ModTime: f.ModTime(),
+			})
+		}
+	}
+	return files, nil
+}
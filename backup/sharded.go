@@ -0,0 +1,323 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/config"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// oplogWait is how long a shard has to reach the cluster time, idle replica sets
+// write a no-op to their oplog every 10 seconds
+const oplogWait = time.Minute
+
+// clusterMember is a shard or the config server replica set
+type clusterMember struct {
+	Name       string
+	Type       string
+	ReplicaSet string
+	Hosts      []string
+}
+
+func (c clusterMember) host() string {
+	if c.ReplicaSet != "" {
+		return c.ReplicaSet + "/" + strings.Join(c.Hosts, ",")
+	}
+	return strings.Join(c.Hosts, ",")
+}
+
+// ListShards returns the shards and the config servers known by mongos
+func (m *MongoClient) ListShards() ([]clusterMember, error) {
+	var shards struct {
+		Shards []struct {
+			ID   string `bson:"_id"`
+			Host string `bson:"host"`
+		} `bson:"shards"`
+	}
+	if err := m.session.Run(bson.M{"listShards": 1}, &shards); err != nil {
+		return nil, errors.Wrap(err, "listShards failed")
+	}
+
+	members := make([]clusterMember, 0, len(shards.Shards)+1)
+	for _, s := range shards.Shards {
+		members = append(members, parseClusterMember(s.ID, "shard", s.Host))
+	}
+
+	var shardMap struct {
+		Map map[string]string `bson:"map"`
+	}
+	if err := m.session.Run(bson.M{"getShardMap": 1}, &shardMap); err != nil {
+		return nil, errors.Wrap(err, "getShardMap failed")
+	}
+	if cfg, ok := shardMap.Map["config"]; ok {
+		members = append(members, parseClusterMember("config", "config", cfg))
+	}
+
+	return members, nil
+}
+
+// parseClusterMember parses the rs/host1,host2 form
+func parseClusterMember(name string, kind string, host string) clusterMember {
+	c := clusterMember{Name: name, Type: kind}
+	if i := strings.Index(host, "/"); i >= 0 {
+		c.ReplicaSet = host[:i]
+		host = host[i+1:]
+	}
+	c.Hosts = strings.Split(host, ",")
+	return c
+}
+
+// lastOplogTimestamp returns the timestamp of the newest oplog entry
+func (m *MongoClient) lastOplogTimestamp() (Timestamp, error) {
+	var entry struct {
+		Ts bson.MongoTimestamp `bson:"ts"`
+	}
+	err := m.session.DB("local").C("oplog.rs").Find(nil).Sort("-$natural").Limit(1).One(&entry)
+	if err != nil {
+		return Timestamp{}, errors.Wrap(err, "reading the last oplog entry failed")
+	}
+	return newTimestamp(entry.Ts), nil
+}
+
+// dumpCluster dumps the config servers and every shard in parallel with --oplog,
+// then copies from each shard the oplog entries up to the most recent dump end,
+// the cluster time. Replaying every shard oplog up to the cluster time restores
+// the whole cluster at the same point in time.
+func dumpCluster(plan config.Plan, conn config.Connection, mc *MongoClient, dir string, name string, stages Stages, log io.Writer) (string, string, error) {
+	members, err := mc.ListShards()
+	if err != nil {
+		return "", "", err
+	}
+	if mongoc := conn.Host.Mongoc; len(mongoc) > 0 {
+		for i, m := range members {
+			if m.Type == "config" {
+				members[i] = parseClusterMember("config", "config", strings.Join(mongoc, ","))
+			}
+		}
+	}
+
+	setDir := filepath.Join(dir, name)
+	if err := os.MkdirAll(setDir, 0755); err != nil {
+		return "", "", errors.Wrapf(err, "creating dir %v failed", setDir)
+	}
+	defer os.RemoveAll(setDir)
+
+	manifest := Manifest{
+		Plan:      plan.Name,
+		Name:      name + ".tar",
+		Timestamp: time.Now().UTC(),
+		Topology:  "sharding",
+	}
+
+	components := make([]Component, len(members))
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m clusterMember) {
+			defer wg.Done()
+			components[i], errs[i] = dumpMember(plan, conn, m, setDir, prefixWriter(log, m.Name))
+		}(i, m)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return "", "", errors.Wrapf(err, "dumping %v failed", members[i].Name)
+		}
+	}
+
+	var clusterTime Timestamp
+	for _, c := range components {
+		if c.OplogEnd.After(clusterTime) {
+			clusterTime = c.OplogEnd
+		}
+	}
+	fmt.Fprintf(log, "cluster time %v\n", clusterTime)
+
+	for i, m := range members {
+		oplog, err := dumpOplog(conn, m, components[i].OplogStart, clusterTime, setDir)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "copying the oplog of %v failed", m.Name)
+		}
+		components[i].OplogFile = oplog
+	}
+
+	if err := dumpMetadata(plan, mc, nil, filepath.Join(setDir, MetadataName), filepath.Join(dir, name+MetadataExt)); err != nil {
+		return "", "", err
+	}
+
+	sort.Slice(components, func(i, j int) bool { return components[i].Name < components[j].Name })
+	manifest.Components = components
+	manifest.ClusterTime = &clusterTime
+
+	if err := writeManifest(filepath.Join(setDir, ManifestName), manifest); err != nil {
+		return "", "", err
+	}
+	sidecar := filepath.Join(dir, name+".json")
+	if err := writeManifest(sidecar, manifest); err != nil {
+		return "", "", err
+	}
+
+	archive := filepath.Join(dir, manifest.Name)
+	err = stages.time("compress", func() error {
+		return tarDir(setDir, archive)
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return archive, sidecar, nil
+}
+
+func dumpMember(plan config.Plan, conn config.Connection, m clusterMember, dir string, log io.Writer) (Component, error) {
+	c := Component{
+		Name:    m.Name,
+		Type:    m.Type,
+		Host:    m.host(),
+		Archive: m.Name + ".gz",
+	}
+
+	conn.URI = ""
+	conn.ReplicaSet = m.ReplicaSet
+	mc, err := NewMongoClient(conn, m.Hosts...)
+	if err != nil {
+		return c, err
+	}
+	defer mc.Close()
+
+	// taken before the dump starts so the oplog copy overlaps the one made by mongodump
+	c.OplogStart, err = mc.lastOplogTimestamp()
+	if err != nil {
+		return c, err
+	}
+
+	if err := _dump(plan, conn, filepath.Join(dir, c.Archive), m.host(), log, "--oplog"); err != nil {
+		return c, err
+	}
+
+	c.OplogEnd, err = mc.lastOplogTimestamp()
+	if err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// dumpOplog writes the oplog entries from start to the cluster time in a BSON file,
+// mongorestore replays it with --oplogFile
+func dumpOplog(conn config.Connection, m clusterMember, start Timestamp, clusterTime Timestamp, dir string) (string, error) {
+	conn.URI = ""
+	conn.ReplicaSet = m.ReplicaSet
+	mc, err := NewMongoClient(conn, m.Hosts...)
+	if err != nil {
+		return "", err
+	}
+	defer mc.Close()
+
+	deadline := time.Now().Add(oplogWait)
+	for {
+		last, err := mc.lastOplogTimestamp()
+		if err != nil {
+			return "", err
+		}
+		if !clusterTime.After(last) {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", errors.Errorf("oplog didn't reach the cluster time %v in %v, last entry %v", clusterTime, oplogWait, last)
+		}
+		time.Sleep(time.Second)
+	}
+
+	name := m.Name + "-oplog.bson"
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return "", errors.Wrapf(err, "creating %v failed", name)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	query := bson.M{"ts": bson.M{"$gte": start.MongoTimestamp(), "$lte": clusterTime.MongoTimestamp()}}
+	iter := mc.session.DB("local").C("oplog.rs").Find(query).Iter()
+	var raw bson.Raw
+	for iter.Next(&raw) {
+		if _, err := w.Write(raw.Data); err != nil {
+			iter.Close()
+			return "", errors.Wrapf(err, "writing %v failed", name)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return "", errors.Wrap(err, "reading the oplog failed")
+	}
+	if err := w.Flush(); err != nil {
+		return "", errors.Wrapf(err, "writing %v failed", name)
+	}
+
+	return name, nil
+}
+
+// tarDir stores the files of dir in an uncompressed tar, the dumps are already gzipped
+func tarDir(dir string, archive string) error {
+	f, err := os.Create(archive)
+	if err != nil {
+		return errors.Wrapf(err, "creating %v failed", archive)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "reading %v failed", dir)
+	}
+	for _, fi := range files {
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return errors.Wrapf(err, "archiving %v failed", fi.Name())
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.Wrapf(err, "archiving %v failed", fi.Name())
+		}
+		src, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "archiving %v failed", fi.Name())
+		}
+		_, err = io.Copy(tw, src)
+		src.Close()
+		if err != nil {
+			return errors.Wrapf(err, "archiving %v failed", fi.Name())
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrapf(err, "writing %v failed", archive)
+	}
+
+	return f.Close()
+}
+
+type lineWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+// prefixWriter tags every line written to w, the tools write one line per call
+func prefixWriter(w io.Writer, prefix string) io.Writer {
+	return lineWriter{w: w, prefix: "[" + prefix + "] "}
+}
+
+func (l lineWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(l.w, l.prefix+string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
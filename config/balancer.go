@@ -0,0 +1,73 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBalancerTimeout is how long, in seconds, a sharded backup waits for the running balancer round
+const DefaultBalancerTimeout = 300
+
+// Balancer controls how sharded backups stop the balancer
+type Balancer struct {
+	// Window is the UTC time range during which the balancer can be stopped
+	Window *BalancerWindow `yaml:"window,omitempty" json:"window,omitempty"`
+	// Timeout in seconds to wait for the running balancer round to finish
+	Timeout int `yaml:"timeout" json:"timeout"`
+}
+
+// BalancerWindow is a daily UTC time range in HH:MM format, it may span midnight
+type BalancerWindow struct {
+	Start string `yaml:"start" json:"start"`
+	Stop  string `yaml:"stop" json:"stop"`
+}
+
+// WaitTimeout returns the configured timeout or the default one
+func (b *Balancer) WaitTimeout() time.Duration {
+	if b == nil || b.Timeout == 0 {
+		return DefaultBalancerTimeout * time.Second
+	}
+	return time.Duration(b.Timeout) * time.Second
+}
+
+// Allows reports whether the balancer can be stopped at t, always true without a window
+func (b *Balancer) Allows(t time.Time) bool {
+	if b == nil || b.Window == nil {
+		return true
+	}
+	start, stop, err := b.Window.parse()
+	if err != nil {
+		return false
+	}
+
+	t = t.UTC()
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if start <= stop {
+		return now >= start && now < stop
+	}
+	return now >= start || now < stop
+}
+
+func (w BalancerWindow) parse() (time.Duration, time.Duration, error) {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "invalid start")
+	}
+	stop, err := parseClock(w.Stop)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "invalid stop")
+	}
+	if start == stop {
+		return 0, 0, errors.New("start and stop must differ")
+	}
+	return start, stop, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.Errorf("%q is not in HH:MM format", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
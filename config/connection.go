@@ -0,0 +1,127 @@
+package config
+
+// Auth mechanisms supported by the mongo tools and the balancer client
+const (
+	AuthScramSHA1   = "SCRAM-SHA-1"
+	AuthScramSHA256 = "SCRAM-SHA-256"
+	AuthX509        = "MONGODB-X509"
+	AuthPlain       = "PLAIN"
+)
+
+// Connection holds the settings needed to connect to a backup or restore target
+type Connection struct {
+	// URI is the connection string, when set Seeds holds its hosts
+	URI           string
+	SRV           bool
+	Seeds         []string
+	Host          Host
+	Database      string
+	Username      string
+	Password      string
+	AuthSource    string
+	AuthMechanism string
+	ReplicaSet    string
+	TLS           *TLS
+}
+
+// Conn returns the connection settings of the backup target
+func (b Backup) Conn() Connection {
+	return Connection{
+		URI:           b.URI,
+		Host:          b.Host,
+		Database:      b.Database,
+		Username:      b.Username,
+		Password:      b.Password,
+		AuthSource:    b.AuthSource,
+		AuthMechanism: b.AuthMechanism,
+		ReplicaSet:    b.ReplicaSet,
+		TLS:           b.TLS,
+	}.withURI()
+}
+
+// Conn returns the connection settings of the restore target
+func (r Restore) Conn() Connection {
+	return Connection{
+		URI:           r.URI,
+		Host:          r.Host,
+		Database:      r.Database,
+		Username:      r.Username,
+		Password:      r.Password,
+		AuthSource:    r.AuthSource,
+		AuthMechanism: r.AuthMechanism,
+		ReplicaSet:    r.ReplicaSet,
+		TLS:           r.TLS,
+	}.withURI()
+}
+
+// Source returns the auth database, x509 and LDAP users are defined in $external
+func (c Connection) Source() string {
+	if c.AuthSource != "" {
+		return c.AuthSource
+	}
+	switch c.AuthMechanism {
+	case AuthX509, AuthPlain:
+		return "$external"
+	}
+	return ""
+}
+
+// withURI fills the settings left blank in the plan with the ones found in the URI
+func (c Connection) withURI() Connection {
+	if c.URI == "" {
+		return c
+	}
+	u, err := ParseURI(c.URI)
+	if err != nil {
+		// invalid URIs are rejected by the plan validation
+		return c
+	}
+
+	c.SRV = u.SRV
+	c.Seeds = u.Hosts
+	if c.Database == "" {
+		c.Database = u.Database
+	}
+	if c.Username == "" {
+		c.Username = u.Username
+	}
+	if c.Password == "" {
+		c.Password = u.Password
+	}
+	if c.AuthSource == "" {
+		c.AuthSource = u.Options.Get("authsource")
+		if c.AuthSource == "" && u.Username != "" {
+			c.AuthSource = u.Database
+		}
+	}
+	if c.AuthMechanism == "" {
+		c.AuthMechanism = u.Options.Get("authmechanism")
+	}
+	if c.ReplicaSet == "" {
+		c.ReplicaSet = u.Options.Get("replicaset")
+	}
+
+	if u.SRV || u.Bool("tls") || u.Bool("ssl") || c.TLS != nil {
+		t := TLS{}
+		if c.TLS != nil {
+			t = *c.TLS
+		}
+		if t.CAFile == "" {
+			t.CAFile = u.Options.Get("tlscafile")
+		}
+		if t.CertFile == "" && t.KeyFile == "" {
+			// the URI file holds both the certificate and the key
+			t.CertFile = u.Options.Get("tlscertificatekeyfile")
+			t.KeyFile = t.CertFile
+		}
+		if t.KeyPassword == "" {
+			t.KeyPassword = u.Options.Get("tlscertificatekeyfilepassword")
+		}
+		if !t.AllowInvalidHostnames {
+			t.AllowInvalidHostnames = u.Bool("tlsAllowInvalidHostnames")
+		}
+		c.TLS = &t
+	}
+
+	return c
+}
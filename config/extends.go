@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultsFile is the name, without extension, of the file holding the
+// settings shared by all plans found in the same dir
+const DefaultsFile = "defaults"
+
+// ReadEffectivePlan returns the named plan merged with its parents and
+// the defaults, the environment and file references are not resolved
+func ReadEffectivePlan(dir string, name string) (Plan, error) {
+	planPath, err := PlanPath(dir, name)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	data, err := ioutil.ReadFile(planPath)
+	if err != nil {
+		return Plan{}, errors.Wrapf(err, "Reading %v failed", planPath)
+	}
+
+	plan, errs := mergePlan(planPath, data)
+	if len(errs) > 0 {
+		return plan, errors.Wrapf(errs, "Loading %v failed", planPath)
+	}
+
+	return plan, nil
+}
+
+// mergePlan strictly parses the plan and deep merges it on top of the plan
+// it extends or, when it doesn't extend any, on top of the defaults.
+// Maps are merged key by key, any other value including lists and null replaces the inherited one.
+func mergePlan(path string, data []byte) (Plan, ValidationErrors) {
+	if _, errs := parsePlan(path, data); len(errs) > 0 {
+		return Plan{}, errs
+	}
+
+	doc, err := inherit(path, data, map[string]bool{})
+	if err != nil {
+		return Plan{}, ValidationErrors{{Field: "extends", Message: err.Error()}}
+	}
+
+	merged, err := yaml.Marshal(doc)
+	if err != nil {
+		return Plan{}, ValidationErrors{{Message: err.Error()}}
+	}
+
+	var plan Plan
+	if err := yaml.UnmarshalStrict(merged, &plan); err != nil {
+		return Plan{}, ValidationErrors{{Message: errors.Wrap(err, "merging plan failed").Error()}}
+	}
+	plan.Name = planName(path)
+
+	return plan, nil
+}
+
+func inherit(path string, data []byte, seen map[string]bool) (map[interface{}]interface{}, error) {
+	name := planName(path)
+	if seen[name] {
+		return nil, errors.Errorf("extends cycle detected at plan %v", name)
+	}
+	seen[name] = true
+
+	doc := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrapf(err, "parsing %v failed", path)
+	}
+
+	dir := filepath.Dir(path)
+	var base map[interface{}]interface{}
+	parent, _ := doc["extends"].(string)
+	if parent != "" {
+		parentPath, err := findFile(dir, parent)
+		if err != nil {
+			return nil, err
+		}
+		parentData, err := ioutil.ReadFile(parentPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %v failed", parentPath)
+		}
+		if _, errs := parsePlan(parentPath, parentData); len(errs) > 0 {
+			return nil, errors.Wrapf(errs, "parsing %v failed", parentPath)
+		}
+		base, err = inherit(parentPath, parentData, seen)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		base, err = defaults(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return merge(base, doc), nil
+}
+
+// defaults loads the defaults file from dir, if any
+func defaults(dir string) (map[interface{}]interface{}, error) {
+	doc := make(map[interface{}]interface{})
+	path, err := findFile(dir, DefaultsFile)
+	if err != nil {
+		return doc, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %v failed", path)
+	}
+	var plan Plan
+	if err := yaml.UnmarshalStrict(data, &plan); err != nil {
+		return nil, errors.Wrapf(err, "parsing %v failed", path)
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrapf(err, "parsing %v failed", path)
+	}
+	delete(doc, "extends")
+
+	return doc, nil
+}
+
+func findFile(dir string, name string) (string, error) {
+	for _, ext := range []string{".yml", ".yaml"} {
+		path := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", errors.Errorf("%v not found in %v", name, dir)
+}
+
+func merge(dst map[interface{}]interface{}, src map[interface{}]interface{}) map[interface{}]interface{} {
+	out := make(map[interface{}]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		out[k] = v
+	}
+	for k, v := range src {
+		sm, srcIsMap := v.(map[interface{}]interface{})
+		dm, dstIsMap := out[k].(map[interface{}]interface{})
+		if srcIsMap && dstIsMap {
+			out[k] = merge(dm, sm)
+			continue
+		}
+		out[k] = v
+	}
+
+	return out
+}
+
+func isDefaultsFile(path string) bool {
+	_, filename := filepath.Split(path)
+	return filename == fmt.Sprintf("%v.yml", DefaultsFile) || filename == fmt.Sprintf("%v.yaml", DefaultsFile)
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	type m = map[interface{}]interface{}
+	tests := []struct {
+		name string
+		dst  m
+		src  m
+		want m
+	}{
+		{
+			name: "src keys are added",
+			dst:  m{"a": 1},
+			src:  m{"b": 2},
+			want: m{"a": 1, "b": 2},
+		},
+		{
+			name: "scalars are replaced",
+			dst:  m{"a": 1},
+			src:  m{"a": 2},
+			want: m{"a": 2},
+		},
+		{
+			name: "maps are merged key by key",
+			dst:  m{"scheduler": m{"cron": "0 6 * * *", "retention": 7}},
+			src:  m{"scheduler": m{"retention": 3}},
+			want: m{"scheduler": m{"cron": "0 6 * * *", "retention": 3}},
+		},
+		{
+			name: "lists are replaced",
+			dst:  m{"mongod": []interface{}{"a", "b"}},
+			src:  m{"mongod": []interface{}{"c"}},
+			want: m{"mongod": []interface{}{"c"}},
+		},
+		{
+			name: "null replaces a map",
+			dst:  m{"smtp": m{"server": "smtp.local"}},
+			src:  m{"smtp": nil},
+			want: m{"smtp": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := merge(tt.dst, tt.src); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePlan(t *testing.T) {
+	defaults := `
+scheduler:
+  cron: "0 6 * * *"
+  retention: 7
+  timeout: 60
+`
+	parent := `
+scheduler:
+  cron: "0 1 * * *"
+target:
+  type: standalone
+  backup:
+    host:
+      mongod:
+        - "parent.local:27017"
+`
+	tests := []struct {
+		name  string
+		files map[string]string
+		plan  string
+		err   string
+		check func(p Plan) string
+	}{
+		{
+			name:  "plan inherits from the defaults",
+			files: map[string]string{"defaults.yml": defaults},
+			plan:  "scheduler:\n  retention: 3\n",
+			check: func(p Plan) string {
+				if p.Scheduler.Cron != "0 6 * * *" || p.Scheduler.Retention != 3 || p.Scheduler.Timeout != 60 {
+					return "scheduler not merged with the defaults"
+				}
+				return ""
+			},
+		},
+		{
+			name:  "parent overrides the defaults",
+			files: map[string]string{"defaults.yml": defaults, "parent.yml": parent},
+			plan:  "extends: parent\nscheduler:\n  timeout: 5\n",
+			check: func(p Plan) string {
+				if p.Scheduler.Cron != "0 1 * * *" || p.Scheduler.Timeout != 5 {
+					return "scheduler not merged with the parent"
+				}
+				if len(p.Target.Backup.Host.Mongod) != 1 || p.Target.Backup.Host.Mongod[0] != "parent.local:27017" {
+					return "target not inherited from the parent"
+				}
+				return ""
+			},
+		},
+		{
+			name:  "missing parent",
+			files: map[string]string{},
+			plan:  "extends: missing\n",
+			err:   "missing not found",
+		},
+		{
+			name:  "extends cycle",
+			files: map[string]string{"a.yml": "extends: b\n", "b.yml": "extends: a\n"},
+			plan:  "extends: a\n",
+			err:   "extends cycle detected",
+		},
+		{
+			name:  "unknown field",
+			files: map[string]string{},
+			plan:  "scheduler:\n  cronn: \"0 6 * * *\"\n",
+			err:   "field cronn not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "mgob-config-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, data := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p, errs := mergePlan(filepath.Join(dir, "plan.yml"), []byte(tt.plan))
+			if tt.err != "" {
+				if len(errs) < 1 || !strings.Contains(errs.Error(), tt.err) {
+					t.Fatalf("mergePlan() errors %v, want %q", errs, tt.err)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("mergePlan() errors %v", errs)
+			}
+			if p.Name != "plan" {
+				t.Errorf("name %q, want plan", p.Name)
+			}
+			if msg := tt.check(p); msg != "" {
+				t.Error(msg)
+			}
+		})
+	}
+}
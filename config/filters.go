@@ -0,0 +1,161 @@
+package config
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Collections filters the dumped namespaces, the patterns match db.collection and accept * wildcards
+type Collections struct {
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	// Queries restricts the documents dumped from a db.collection
+	Queries map[string]Query `yaml:"queries,omitempty" json:"queries,omitempty"`
+}
+
+// Query selects the documents of a collection, the filter and the max age are combined
+type Query struct {
+	// Filter is a mongodump extended JSON query
+	Filter string `yaml:"filter,omitempty" json:"filter,omitempty"`
+	// Field is the date field compared to MaxAge
+	Field string `yaml:"field,omitempty" json:"field,omitempty"`
+	// MaxAge keeps the documents newer than this age, e.g. 90d or 12h
+	MaxAge string `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
+}
+
+// PerDatabase discovers the databases of the target and dumps each one matching
+// the patterns in its own archive, stored in its own dir with its own retention
+type PerDatabase struct {
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+}
+
+// Matches reports whether the database is selected, admin, local and config must be included by name
+func (p *PerDatabase) Matches(db string) bool {
+	switch db {
+	case "admin", "local", "config":
+		for _, name := range p.Include {
+			if name == db {
+				return true
+			}
+		}
+		return false
+	}
+	if len(p.Include) > 0 && !matchAny(p.Include, db) {
+		return false
+	}
+	return !matchAny(p.Exclude, db)
+}
+
+// Filtered reports whether the dump is split per database or filtered per collection
+func (b Backup) Filtered() bool {
+	return len(b.Databases) > 0 || b.Collections != nil
+}
+
+// Matches reports whether the db.collection namespace is selected by the filters
+func (c *Collections) Matches(ns string) bool {
+	if c == nil {
+		return true
+	}
+	if len(c.Include) > 0 && !matchAny(c.Include, ns) {
+		return false
+	}
+	return !matchAny(c.Exclude, ns)
+}
+
+func matchAny(patterns []string, ns string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, ns); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON returns the mongodump --query value, now is the reference for MaxAge
+func (q Query) JSON(now time.Time) (string, error) {
+	parts := make([]string, 0, 2)
+	if q.Filter != "" {
+		if !json.Valid([]byte(q.Filter)) {
+			return "", errors.New("filter is not valid JSON")
+		}
+		parts = append(parts, q.Filter)
+	}
+	if q.MaxAge != "" {
+		age, err := ParseAge(q.MaxAge)
+		if err != nil {
+			return "", err
+		}
+		since := now.Add(-age).UTC().Format(time.RFC3339)
+		field, _ := json.Marshal(q.Field)
+		parts = append(parts, `{`+string(field)+`: {"$gte": {"$date": "`+since+`"}}}`)
+	}
+
+	switch len(parts) {
+	case 0:
+		return "", errors.New("filter or maxAge is required")
+	case 1:
+		return parts[0], nil
+	}
+	return `{"$and": [` + strings.Join(parts, ", ") + `]}`, nil
+}
+
+// ParseAge parses a Go duration, a number of days can be given with the d suffix
+func ParseAge(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days < 1 {
+			return 0, errors.Errorf("invalid age %q, use a number of days like 90d or a duration like 12h", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, errors.Errorf("invalid age %q, use a number of days like 90d or a duration like 12h", value)
+	}
+	return d, nil
+}
+
+func validatePerDatabase(p *PerDatabase, add func(field string, format string, args ...interface{})) {
+	for _, list := range []struct {
+		field    string
+		patterns []string
+	}{{"include", p.Include}, {"exclude", p.Exclude}} {
+		for _, pattern := range list.patterns {
+			if _, err := path.Match(pattern, ""); err != nil || strings.ContainsAny(pattern, "./") {
+				add("target.backup.perDatabase."+list.field, "invalid database pattern %q", pattern)
+			}
+		}
+	}
+}
+
+func validateCollections(c *Collections, add func(field string, format string, args ...interface{})) {
+	for _, list := range []struct {
+		field    string
+		patterns []string
+	}{{"include", c.Include}, {"exclude", c.Exclude}} {
+		for _, p := range list.patterns {
+			if _, err := path.Match(p, ""); err != nil || !strings.Contains(p, ".") {
+				add("target.backup.collections."+list.field, "invalid pattern %q, the format is db.collection", p)
+			}
+		}
+	}
+
+	for ns, q := range c.Queries {
+		field := "target.backup.collections.queries." + ns
+		if i := strings.Index(ns, "."); i < 1 || i == len(ns)-1 || strings.ContainsAny(ns, "*?[") {
+			add(field, "invalid namespace, the format is db.collection without wildcards")
+		}
+		if (q.Field == "") != (q.MaxAge == "") {
+			add(field, "field and maxAge must be set together")
+		}
+		if _, err := q.JSON(time.Now()); err != nil {
+			add(field, "%v", err)
+		}
+	}
+}
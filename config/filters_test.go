@@ -0,0 +1,126 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollectionsMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		c     *Collections
+		ns    string
+		match bool
+	}{
+		{name: "nil filter", c: nil, ns: "shop.orders", match: true},
+		{name: "empty filter", c: &Collections{}, ns: "shop.orders", match: true},
+		{name: "included", c: &Collections{Include: []string{"shop.orders"}}, ns: "shop.orders", match: true},
+		{name: "not included", c: &Collections{Include: []string{"shop.orders"}}, ns: "shop.users", match: false},
+		{name: "wildcard collection", c: &Collections{Include: []string{"shop.*"}}, ns: "shop.users", match: true},
+		{name: "wildcard database", c: &Collections{Include: []string{"*.orders"}}, ns: "sales.orders", match: true},
+		{name: "wildcard spans the dot", c: &Collections{Include: []string{"shop*"}}, ns: "shop.orders", match: true},
+		{name: "excluded", c: &Collections{Exclude: []string{"shop.logs"}}, ns: "shop.logs", match: false},
+		{name: "exclude wins", c: &Collections{Include: []string{"shop.*"}, Exclude: []string{"shop.cache_*"}}, ns: "shop.cache_users", match: false},
+		{name: "included and not excluded", c: &Collections{Include: []string{"shop.*"}, Exclude: []string{"shop.cache_*"}}, ns: "shop.users", match: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.Matches(tt.ns); got != tt.match {
+				t.Errorf("Matches(%q) = %v, want %v", tt.ns, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestQueryJSON(t *testing.T) {
+	now := time.Date(2018, 4, 10, 6, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+		err   bool
+	}{
+		{name: "filter", query: Query{Filter: `{"status": "paid"}`}, want: `{"status": "paid"}`},
+		{name: "max age in days", query: Query{Field: "createdAt", MaxAge: "90d"},
+			want: `{"createdAt": {"$gte": {"$date": "2018-01-10T06:00:00Z"}}}`},
+		{name: "max age as a duration", query: Query{Field: "createdAt", MaxAge: "12h"},
+			want: `{"createdAt": {"$gte": {"$date": "2018-04-09T18:00:00Z"}}}`},
+		{name: "filter and max age", query: Query{Filter: `{"status": "paid"}`, Field: "createdAt", MaxAge: "1d"},
+			want: `{"$and": [{"status": "paid"}, {"createdAt": {"$gte": {"$date": "2018-04-09T06:00:00Z"}}}]}`},
+		{name: "field name is escaped", query: Query{Field: `a"b`, MaxAge: "1d"},
+			want: `{"a\"b": {"$gte": {"$date": "2018-04-09T06:00:00Z"}}}`},
+		{name: "invalid filter", query: Query{Filter: `{status: paid`}, err: true},
+		{name: "invalid max age", query: Query{Field: "createdAt", MaxAge: "3 months"}, err: true},
+		{name: "empty query", query: Query{}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.query.JSON(now)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("JSON() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("JSON() error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("JSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "90d", want: 90 * 24 * time.Hour},
+		{value: "12h", want: 12 * time.Hour},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "0d", err: true},
+		{value: "-1h", err: true},
+		{value: "d", err: true},
+		{value: "90", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAge(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseAge(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestPerDatabaseMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     PerDatabase
+		db    string
+		match bool
+	}{
+		{name: "every database", p: PerDatabase{}, db: "shop", match: true},
+		{name: "admin is skipped", p: PerDatabase{}, db: "admin", match: false},
+		{name: "local is skipped", p: PerDatabase{Include: []string{"*"}}, db: "local", match: false},
+		{name: "config included by name", p: PerDatabase{Include: []string{"config"}}, db: "config", match: true},
+		{name: "admin included by name", p: PerDatabase{Include: []string{"shop", "admin"}}, db: "admin", match: true},
+		{name: "included pattern", p: PerDatabase{Include: []string{"shop_*"}}, db: "shop_eu", match: true},
+		{name: "not included", p: PerDatabase{Include: []string{"shop_*"}}, db: "sales", match: false},
+		{name: "excluded", p: PerDatabase{Exclude: []string{"*_test"}}, db: "shop_test", match: false},
+		{name: "exclude wins", p: PerDatabase{Include: []string{"shop_*"}, Exclude: []string{"shop_test"}}, db: "shop_test", match: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Matches(tt.db); got != tt.match {
+				t.Errorf("Matches(%q) = %v, want %v", tt.db, got, tt.match)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secret files are referenced with the file: prefix e.g. file:/run/secrets/s3-key
+const filePrefix = "file:"
+
+var envRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// referenceRegexp matches a value made of a single env var reference
+var referenceRegexp = regexp.MustCompile(`^\$\{[A-Za-z_][A-Za-z0-9_]*\}$`)
+
+// Resolve returns a copy of the plan with every ${ENV_VAR} expanded
+// and every file: reference replaced by the file content.
+// The errors name the field and the reference but never the resolved value.
+func (p Plan) Resolve() (Plan, error) {
+	r := p
+	errs := ValidationErrors{}
+	resolveValue(reflect.ValueOf(&r).Elem(), "", &errs)
+	if len(errs) > 0 {
+		return p, errs
+	}
+
+	return r, nil
+}
+
+// IsReference reports whether the whole value is resolved from the environment or a file,
+// a value mixing a reference with literal text may hold part of a secret
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, filePrefix) || referenceRegexp.MatchString(value)
+}
+
+// resolveValue walks the plan in place, pointers, slices and maps are copied
+// before being modified so the original plan is left untouched
+func resolveValue(v reflect.Value, path string, errs *ValidationErrors) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.New(v.Elem().Type())
+		c.Elem().Set(v.Elem())
+		v.Set(c)
+		resolveValue(c.Elem(), path, errs)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			resolveValue(v.Field(i), name, errs)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		v.Set(c)
+		for i := 0; i < c.Len(); i++ {
+			resolveValue(c.Index(i), path, errs)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.MakeMap(v.Type())
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			resolveValue(e, fmt.Sprintf("%v.%v", path, k), errs)
+			c.SetMapIndex(k, e)
+		}
+		v.Set(c)
+	case reflect.String:
+		s, err := interpolate(v.String())
+		if err != nil {
+			*errs = append(*errs, ValidationError{Field: path, Message: err.Error()})
+			return
+		}
+		v.SetString(s)
+	}
+}
+
+func interpolate(value string) (string, error) {
+	if strings.HasPrefix(value, filePrefix) {
+		path := strings.TrimPrefix(value, filePrefix)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", errors.Errorf("secret file %v not found", path)
+			}
+			return "", errors.Wrapf(err, "reading secret file %v failed", path)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var missing []string
+	s := envRegexp.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRegexp.FindStringSubmatch(ref)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return env
+	})
+	if len(missing) > 0 {
+		return "", errors.Errorf("environment variable %v is not set", strings.Join(missing, ", "))
+	}
+
+	return s, nil
+}
@@ -7,123 +7,232 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
+	"github.com/vtomasr5/mgob/redact"
 )
 
+// ErrPlanNotFound is returned when no file matches the plan name
+var ErrPlanNotFound = errors.New("not found")
+
 type Plan struct {
-	Name      string    `yaml:"name"`
-	Target    Target    `yaml:"target"`  // backup from
-	Restore   Restore   `yaml:"restore"` // restore to
-	Scheduler Scheduler `yaml:"scheduler"`
-	S3        *S3       `yaml:"s3"`
-	SFTP      *SFTP     `yaml:"sftp"`
-	SMTP      *SMTP     `yaml:"smtp"`
-	Slack     *Slack    `yaml:"slack"`
+	Name      string    `yaml:"name" json:"name"`
+	Extends   string    `yaml:"extends,omitempty" json:"extends,omitempty"` // inherit from plan
+	Target    Target    `yaml:"target" json:"target"`                       // backup from
+	Restore   Restore   `yaml:"restore" json:"restore"`                     // restore to
+	Scheduler Scheduler `yaml:"scheduler" json:"scheduler"`
+	Anomaly   *Anomaly  `yaml:"anomaly,omitempty" json:"anomaly,omitempty"`
+	S3        *S3       `yaml:"s3,omitempty" json:"s3,omitempty"`
+	SFTP      *SFTP     `yaml:"sftp,omitempty" json:"sftp,omitempty"`
+	SMTP      *SMTP     `yaml:"smtp,omitempty" json:"smtp,omitempty"`
+	Slack     *Slack    `yaml:"slack,omitempty" json:"slack,omitempty"`
 }
 
 type Target struct {
-	Type    string  `yaml:"type"`
-	Backup  Backup  `yaml:"backup"`
-	Restore Restore `yaml:"restore"`
+	Type    string  `yaml:"type" json:"type"`
+	Backup  Backup  `yaml:"backup" json:"backup"`
+	Restore Restore `yaml:"restore" json:"restore"`
 }
 
 type Backup struct {
-	Database string `yaml:"database"`
-	Host     Host   `yaml:"host"`
-	Password string `yaml:"password"`
-	Username string `yaml:"username"`
-	Type     string `yaml:"type"`
+	URI           string    `yaml:"uri,omitempty" json:"uri,omitempty"`
+	Database      string    `yaml:"database" json:"database"`
+	Host          Host      `yaml:"host" json:"host"`
+	Password      string    `yaml:"password" json:"password"`
+	Username      string    `yaml:"username" json:"username"`
+	Type          string    `yaml:"type" json:"type"`
+	AuthSource    string    `yaml:"authSource,omitempty" json:"authSource,omitempty"`
+	AuthMechanism string    `yaml:"authMechanism,omitempty" json:"authMechanism,omitempty"`
+	ReplicaSet    string    `yaml:"replicaSet,omitempty" json:"replicaSet,omitempty"`
+	TLS           *TLS      `yaml:"tls,omitempty" json:"tls,omitempty"`
+	Member        *Member   `yaml:"member,omitempty" json:"member,omitempty"`
+	Balancer      *Balancer `yaml:"balancer,omitempty" json:"balancer,omitempty"`
+	// Consistent dumps every shard in parallel up to a common cluster time
+	Consistent bool `yaml:"consistent,omitempty" json:"consistent,omitempty"`
+	// Databases lists the databases to dump, each one in its own archive
+	Databases   []string     `yaml:"databases,omitempty" json:"databases,omitempty"`
+	Collections *Collections `yaml:"collections,omitempty" json:"collections,omitempty"`
+	PerDatabase *PerDatabase `yaml:"perDatabase,omitempty" json:"perDatabase,omitempty"`
+	// UsersAndRoles dumps the users and roles defined on the dumped databases
+	UsersAndRoles bool `yaml:"usersAndRoles,omitempty" json:"usersAndRoles,omitempty"`
+	// Admin adds a full dump of the admin database to a single database backup
+	Admin bool `yaml:"admin,omitempty" json:"admin,omitempty"`
+}
+
+// Member selects the replica set member the backup is taken from, healthy secondaries
+// are preferred, hidden ones first, delayed members and arbiters are never used
+type Member struct {
+	// Tags the member must have e.g. use: backup
+	Tags map[string]string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	// MaxLag is the replication lag limit in seconds, zero means no limit
+	MaxLag int `yaml:"maxLag" json:"maxLag"`
+	// OnLag is fail or skip, what to do when every secondary is lagging
+	OnLag string `yaml:"onLag,omitempty" json:"onLag,omitempty"`
+	// AllowPrimary dumps from the primary when no secondary is eligible
+	AllowPrimary bool `yaml:"allowPrimary" json:"allowPrimary"`
 }
 
+// Member lag policies
+const (
+	OnLagFail = "fail"
+	OnLagSkip = "skip"
+)
+
 type Restore struct {
-	Database string `yaml:"database"`
-	Host     Host   `yaml:"host"`
-	Password string `yaml:"password"`
-	Username string `yaml:"username"`
-	Type     string `yaml:"type"`
+	URI           string `yaml:"uri,omitempty" json:"uri,omitempty"`
+	Database      string `yaml:"database" json:"database"`
+	Host          Host   `yaml:"host" json:"host"`
+	Password      string `yaml:"password" json:"password"`
+	Username      string `yaml:"username" json:"username"`
+	Type          string `yaml:"type" json:"type"`
+	AuthSource    string `yaml:"authSource,omitempty" json:"authSource,omitempty"`
+	AuthMechanism string `yaml:"authMechanism,omitempty" json:"authMechanism,omitempty"`
+	ReplicaSet    string `yaml:"replicaSet,omitempty" json:"replicaSet,omitempty"`
+	TLS           *TLS   `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// Shards maps the backup shard names to the restore target shard names
+	Shards map[string]string `yaml:"shards,omitempty" json:"shards,omitempty"`
+}
+
+type TLS struct {
+	CAFile                string `yaml:"caFile" json:"caFile"`
+	CertFile              string `yaml:"certFile" json:"certFile"`
+	KeyFile               string `yaml:"keyFile" json:"keyFile"`
+	KeyPassword           string `yaml:"keyPassword" json:"keyPassword"`
+	AllowInvalidHostnames bool   `yaml:"allowInvalidHostnames" json:"allowInvalidHostnames"`
 }
 
 type Host struct {
-	Mongod []string `yaml:"mongod"`
-	Mongos []string `yaml:"mongos"`
-	Mongoc []string `yaml:"mongoc"`
+	Mongod []string `yaml:"mongod" json:"mongod"`
+	Mongos []string `yaml:"mongos" json:"mongos"`
+	Mongoc []string `yaml:"mongoc" json:"mongoc"`
 }
 
 type Scheduler struct {
-	Cron      string `yaml:"cron"`
-	Retention int    `yaml:"retention"`
-	Timeout   int    `yaml:"timeout"`
+	Cron      string `yaml:"cron" json:"cron"`
+	Retention int    `yaml:"retention" json:"retention"`
+	Timeout   int    `yaml:"timeout" json:"timeout"`
+	// MaxAge is the RPO in minutes, the age limit of the last successful backup, zero disables the watchdog
+	MaxAge int `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
+}
+
+// Anomaly flags a successful run as suspicious when it differs too much from the previous runs
+type Anomaly struct {
+	// SizeChange is the allowed size change in percent against the average of the history, zero disables it
+	SizeChange float64 `yaml:"sizeChange" json:"sizeChange"`
+	// CountChange is the allowed document count change of a collection in percent against the previous run,
+	// zero doesn't count the documents
+	CountChange float64 `yaml:"countChange,omitempty" json:"countChange,omitempty"`
+	// History is the number of runs kept, 7 by default
+	History int `yaml:"history,omitempty" json:"history,omitempty"`
+}
+
+// Runs returns the number of runs kept in the history
+func (a Anomaly) Runs() int {
+	if a.History < 1 {
+		return 7
+	}
+	return a.History
 }
 
 type S3 struct {
-	Bucket    string `yaml:"bucket"`
-	AccessKey string `yaml:"accessKey"`
-	API       string `yaml:"api"`
-	SecretKey string `yaml:"secretKey"`
-	URL       string `yaml:"url"`
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	AccessKey string `yaml:"accessKey" json:"accessKey"`
+	// API is ignored, mc detects the signature version of the host
+	API       string `yaml:"api,omitempty" json:"api,omitempty"`
+	SecretKey string `yaml:"secretKey" json:"secretKey"`
+	URL       string `yaml:"url" json:"url"`
 }
 
 type SFTP struct {
-	BackupDir  string `yaml:"backupdir"`
-	RestoreDir string `yaml:"restoredir"`
-	Host       string `yaml:"host"`
-	Password   string `yaml:"password"`
-	Port       int    `yaml:"port"`
-	Username   string `yaml:"username"`
+	BackupDir  string `yaml:"backupdir" json:"backupdir"`
+	RestoreDir string `yaml:"restoredir" json:"restoredir"`
+	Host       string `yaml:"host" json:"host"`
+	Password   string `yaml:"password" json:"password"`
+	Port       int    `yaml:"port" json:"port"`
+	Username   string `yaml:"username" json:"username"`
 }
 
 type SMTP struct {
-	Server   string   `yaml:"server"`
-	Port     string   `yaml:"port"`
-	Password string   `yaml:"password"`
-	Username string   `yaml:"username"`
-	From     string   `yaml:"from"`
-	To       []string `yaml:"to"`
+	Server   string   `yaml:"server" json:"server"`
+	Port     string   `yaml:"port" json:"port"`
+	Password string   `yaml:"password" json:"password"`
+	Username string   `yaml:"username" json:"username"`
+	From     string   `yaml:"from" json:"from"`
+	To       []string `yaml:"to" json:"to"`
 }
 
 type Slack struct {
-	URL      string `yaml:"url"`
-	Channel  string `yaml:"channel"`
-	Username string `yaml:"username"`
-	WarnOnly bool   `yaml:"warnOnly"`
+	URL      string `yaml:"url" json:"url"`
+	Channel  string `yaml:"channel" json:"channel"`
+	Username string `yaml:"username" json:"username"`
+	WarnOnly bool   `yaml:"warnOnly" json:"warnOnly"`
 }
 
 func LoadPlan(dir string, name string) (Plan, error) {
-	plan := Plan{}
+	planPath, err := PlanPath(dir, name)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	return LoadPlanFile(planPath)
+}
+
+// PlanPath finds the file of the named plan inside dir
+func PlanPath(dir string, name string) (string, error) {
+	if name == DefaultsFile {
+		return "", errors.Wrapf(ErrPlanNotFound, "Plan %v", name)
+	}
+
 	planPath := ""
 	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if strings.Contains(path, name+".yml") || strings.Contains(path, name+".yaml") {
+		_, filename := filepath.Split(path)
+		if filename == name+".yml" || filename == name+".yaml" {
 			planPath = path
 		}
 		return nil
 	})
 
 	if err != nil {
-		return plan, errors.Wrapf(err, "Reading from %v failed", dir)
+		return "", errors.Wrapf(err, "Reading from %v failed", dir)
 	}
 
 	if len(planPath) < 1 {
-		return plan, errors.Errorf("Plan %v not found", name)
+		return "", errors.Wrapf(ErrPlanNotFound, "Plan %v", name)
 	}
 
-	data, err := ioutil.ReadFile(planPath)
+	return planPath, nil
+}
+
+// LoadPlans parses all plan files found in dir
+func LoadPlans(dir string) ([]Plan, error) {
+	files, err := PlanFiles(dir)
 	if err != nil {
-		return plan, errors.Wrapf(err, "Reading %v failed", planPath)
+		return nil, err
 	}
 
-	if err := yaml.Unmarshal(data, &plan); err != nil {
-		return plan, errors.Wrapf(err, "Parsing %v failed", planPath)
+	plans := make([]Plan, 0)
+
+	for _, path := range files {
+		plan, err := LoadPlanFile(path)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+
+	}
+	if len(plans) < 1 {
+		return nil, errors.Errorf("No backup plans found in %v", dir)
 	}
-	_, filename := filepath.Split(planPath)
-	plan.Name = strings.TrimSuffix(filename, filepath.Ext(filename))
 
-	return plan, nil
+	return plans, nil
 }
 
-func LoadPlans(dir string) ([]Plan, error) {
+// PlanFiles lists the yml files found in dir, except the defaults and hidden files
+func PlanFiles(dir string) ([]string, error) {
 	files := []string{}
 	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if strings.Contains(path, "yml") || strings.Contains(path, "yaml") {
+		if err != nil || f.IsDir() || strings.HasPrefix(f.Name(), ".") || isDefaultsFile(path) {
+			return nil
+		}
+		if ext := filepath.Ext(path); ext == ".yml" || ext == ".yaml" {
 			files = append(files, path)
 		}
 		return nil
@@ -133,26 +242,89 @@ func LoadPlans(dir string) ([]Plan, error) {
 		return nil, errors.Wrapf(err, "Reading from %v failed", dir)
 	}
 
-	plans := make([]Plan, 0)
+	return files, nil
+}
 
-	for _, path := range files {
-		var plan Plan
-		data, err := ioutil.ReadFile(path)
+// LoadPlanFile strictly parses, merges, resolves and validates a plan file,
+// the plan name is the file name without extension
+func LoadPlanFile(path string) (Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Plan{}, errors.Wrapf(err, "Reading %v failed", path)
+	}
+
+	plan, errs := loadPlan(path, data)
+	if len(errs) > 0 {
+		return plan, errors.Wrapf(errs, "Loading %v failed", path)
+	}
+
+	return plan, nil
+}
+
+// ReadPlanFile strictly parses a plan file without merging the defaults and
+// without resolving the environment and file references,
+// use it when the plan is displayed or saved
+func ReadPlanFile(path string) (Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Plan{}, errors.Wrapf(err, "Reading %v failed", path)
+	}
+
+	plan, errs := parsePlan(path, data)
+	if len(errs) > 0 {
+		return plan, errors.Wrapf(errs, "Parsing %v failed", path)
+	}
+
+	return plan, nil
+}
+
+// ReadPlanData returns the document of the named plan as stored
+func ReadPlanData(dir string, name string) ([]byte, error) {
+	planPath, err := PlanPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(planPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Reading %v failed", planPath)
+	}
+
+	return data, nil
+}
+
+// ReadPlan finds and parses the named plan without resolving it
+func ReadPlan(dir string, name string) (Plan, error) {
+	planPath, err := PlanPath(dir, name)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	return ReadPlanFile(planPath)
+}
+
+func loadPlan(path string, data []byte) (Plan, ValidationErrors) {
+	plan, errs := mergePlan(path, data)
+	if len(errs) < 1 {
+		resolved, err := plan.Resolve()
 		if err != nil {
-			return nil, errors.Wrapf(err, "Reading %v failed", path)
+			errs = err.(ValidationErrors)
+		} else {
+			plan = resolved
+			redact.Register(plan.Secrets()...)
+			errs = plan.validate()
 		}
+	}
 
-		if err := yaml.Unmarshal(data, &plan); err != nil {
-			return nil, errors.Wrapf(err, "Parsing %v failed", path)
+	for i := range errs {
+		if errs[i].Line < 1 {
+			errs[i].Line = lineOf(data, errs[i].Field)
 		}
-		_, filename := filepath.Split(path)
-		plan.Name = strings.TrimSuffix(filename, filepath.Ext(filename))
-		plans = append(plans, plan)
-
-	}
-	if len(plans) < 1 {
-		return nil, errors.Errorf("No backup plans found in %v", dir)
 	}
+	return plan, errs
+}
 
-	return plans, nil
+func planName(path string) string {
+	_, filename := filepath.Split(path)
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
 }
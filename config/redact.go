@@ -0,0 +1,152 @@
+package config
+
+import (
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/vtomasr5/mgob/redact"
+	"gopkg.in/yaml.v2"
+)
+
+// SecretMask is the placeholder returned instead of a secret
+const SecretMask = redact.Mask
+
+// Redacted returns a copy of the plan with passwords, keys and webhooks masked,
+// environment and file references are left visible
+func (p Plan) Redacted() Plan {
+	r := p
+	r.Target.Backup.URI = maskURI(p.Target.Backup.URI)
+	r.Target.Backup.Password = maskSecret(p.Target.Backup.Password)
+	r.Target.Backup.TLS = maskTLS(p.Target.Backup.TLS)
+	r.Target.Restore.URI = maskURI(p.Target.Restore.URI)
+	r.Target.Restore.Password = maskSecret(p.Target.Restore.Password)
+	r.Target.Restore.TLS = maskTLS(p.Target.Restore.TLS)
+	r.Restore.URI = maskURI(p.Restore.URI)
+	r.Restore.Password = maskSecret(p.Restore.Password)
+	r.Restore.TLS = maskTLS(p.Restore.TLS)
+
+	if p.S3 != nil {
+		s3 := *p.S3
+		s3.SecretKey = maskSecret(s3.SecretKey)
+		r.S3 = &s3
+	}
+	if p.SFTP != nil {
+		sftp := *p.SFTP
+		sftp.Password = maskSecret(sftp.Password)
+		r.SFTP = &sftp
+	}
+	if p.SMTP != nil {
+		smtp := *p.SMTP
+		smtp.Password = maskSecret(smtp.Password)
+		r.SMTP = &smtp
+	}
+	if p.Slack != nil {
+		slack := *p.Slack
+		slack.URL = maskSecret(slack.URL)
+		r.Slack = &slack
+	}
+
+	return r
+}
+
+// UnredactPlan replaces the masked secrets of a plan document with the values found at the same
+// place in the previous version of the document, this allows a redacted plan to be read,
+// edited and submitted back
+func UnredactPlan(data []byte, prev []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "Parsing plan failed")
+	}
+	var prevDoc yaml.MapSlice
+	if err := yaml.Unmarshal(prev, &prevDoc); err != nil {
+		return nil, errors.Wrap(err, "Parsing the previous plan failed")
+	}
+
+	out, err := yaml.Marshal(unredactValue(doc, prevDoc))
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshaling plan failed")
+	}
+	return out, nil
+}
+
+func unredactValue(v interface{}, prev interface{}) interface{} {
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		prevMap, _ := prev.(yaml.MapSlice)
+		out := make(yaml.MapSlice, 0, len(t))
+		for _, item := range t {
+			var prevValue interface{}
+			for _, p := range prevMap {
+				if p.Key == item.Key {
+					prevValue = p.Value
+				}
+			}
+			out = append(out, yaml.MapItem{Key: item.Key, Value: unredactValue(item.Value, prevValue)})
+		}
+		return out
+	case string:
+		p, _ := prev.(string)
+		if t == SecretMask {
+			return unmaskSecret(t, p)
+		}
+		return unmaskURI(t, p)
+	}
+	return v
+}
+
+func maskTLS(t *TLS) *TLS {
+	if t == nil {
+		return nil
+	}
+	r := *t
+	r.KeyPassword = maskSecret(t.KeyPassword)
+	return &r
+}
+
+func maskSecret(secret string) string {
+	// references don't disclose the secret
+	if secret == "" || IsReference(secret) {
+		return secret
+	}
+	return SecretMask
+}
+
+func unmaskSecret(value string, prev string) string {
+	if value == SecretMask {
+		return prev
+	}
+	return value
+}
+
+// Secrets lists the passwords, keys and webhooks of the plan
+func (p Plan) Secrets() []string {
+	secrets := []string{
+		p.Target.Backup.Password,
+		p.Target.Restore.Password,
+		p.Restore.Password,
+	}
+	for _, uri := range []string{p.Target.Backup.URI, p.Target.Restore.URI, p.Restore.URI} {
+		if u, err := ParseURI(uri); err == nil {
+			secrets = append(secrets, u.Password, url.QueryEscape(u.Password))
+		}
+	}
+	for _, t := range []*TLS{p.Target.Backup.TLS, p.Target.Restore.TLS, p.Restore.TLS} {
+		if t != nil {
+			secrets = append(secrets, t.KeyPassword)
+		}
+	}
+	if p.S3 != nil {
+		secrets = append(secrets, p.S3.SecretKey)
+	}
+	if p.SFTP != nil {
+		secrets = append(secrets, p.SFTP.Password)
+	}
+	if p.SMTP != nil {
+		secrets = append(secrets, p.SMTP.Password)
+	}
+	if p.Slack != nil {
+		secrets = append(secrets, p.Slack.URL)
+	}
+
+	return secrets
+}
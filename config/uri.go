@@ -0,0 +1,158 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// URI is a parsed MongoDB connection string
+type URI struct {
+	SRV      bool
+	Hosts    []string
+	Username string
+	Password string
+	Database string
+	Options  url.Values
+}
+
+// ParseURI parses a mongodb:// or mongodb+srv:// connection string,
+// the option names are case insensitive and are returned lower cased
+func ParseURI(uri string) (URI, error) {
+	res := URI{Options: url.Values{}}
+
+	var rest string
+	switch {
+	case strings.HasPrefix(uri, "mongodb://"):
+		rest = strings.TrimPrefix(uri, "mongodb://")
+	case strings.HasPrefix(uri, "mongodb+srv://"):
+		res.SRV = true
+		rest = strings.TrimPrefix(uri, "mongodb+srv://")
+	default:
+		return res, errors.New("scheme must be mongodb:// or mongodb+srv://")
+	}
+
+	// the host list can't be parsed by net/url, split it by hand
+	if i := strings.Index(rest, "?"); i >= 0 {
+		query, err := url.ParseQuery(rest[i+1:])
+		if err != nil {
+			return res, errors.Wrap(err, "invalid options")
+		}
+		for k, v := range query {
+			res.Options[strings.ToLower(k)] = v
+		}
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		db, err := url.PathUnescape(rest[i+1:])
+		if err != nil {
+			return res, errors.Wrap(err, "invalid database")
+		}
+		res.Database = db
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		userinfo := rest[:i]
+		rest = rest[i+1:]
+		user := userinfo
+		if j := strings.Index(userinfo, ":"); j >= 0 {
+			user = userinfo[:j]
+			password, err := url.PathUnescape(userinfo[j+1:])
+			if err != nil {
+				return res, errors.New("invalid password encoding")
+			}
+			res.Password = password
+		}
+		username, err := url.PathUnescape(user)
+		if err != nil {
+			return res, errors.New("invalid username encoding")
+		}
+		res.Username = username
+	}
+
+	for _, h := range strings.Split(rest, ",") {
+		if h != "" {
+			res.Hosts = append(res.Hosts, h)
+		}
+	}
+	if len(res.Hosts) < 1 {
+		return res, errors.New("no host found")
+	}
+	if res.SRV && (len(res.Hosts) != 1 || strings.Contains(res.Hosts[0], ":")) {
+		return res, errors.New("mongodb+srv:// requires a single host name without port")
+	}
+
+	return res, nil
+}
+
+// Topology guesses the target type, a replica set is named in the options,
+// a single host is a standalone server, anything else is ambiguous
+func (u URI) Topology() string {
+	if u.Options.Get("replicaset") != "" {
+		return "replicaset"
+	}
+	if !u.SRV && len(u.Hosts) == 1 {
+		return "standalone"
+	}
+	return ""
+}
+
+// Bool returns a boolean option
+func (u URI) Bool(name string) bool {
+	return strings.ToLower(u.Options.Get(strings.ToLower(name))) == "true"
+}
+
+// Topology returns the target type, when blank it's guessed from the URI
+func (t Target) Topology() string {
+	if t.Type != "" || t.Backup.URI == "" {
+		return t.Type
+	}
+	u, err := ParseURI(t.Backup.URI)
+	if err != nil {
+		return ""
+	}
+	return u.Topology()
+}
+
+// maskURI hides the password of a connection string
+func maskURI(uri string) string {
+	i := strings.Index(uri, "://")
+	if i < 0 || IsReference(uri) {
+		return uri
+	}
+	i += 3
+
+	rest := uri[i:]
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority := rest[:end]
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return uri
+	}
+	colon := strings.Index(authority[:at], ":")
+	if colon < 0 {
+		return uri
+	}
+
+	return uri[:i] + authority[:colon+1] + SecretMask + authority[at:] + rest[end:]
+}
+
+// unmaskURI restores the password of a masked connection string from the previous version
+func unmaskURI(uri string, prev string) string {
+	if uri == "" || prev == "" || !strings.Contains(uri, ":"+SecretMask+"@") {
+		return uri
+	}
+	// the masked URI is unchanged apart from the password
+	if maskURI(prev) == uri {
+		return prev
+	}
+	u, err := ParseURI(prev)
+	if err != nil {
+		return uri
+	}
+	return strings.Replace(uri, ":"+SecretMask+"@", ":"+strings.Replace(url.QueryEscape(u.Password), "+", "%20", -1)+"@", 1)
+}
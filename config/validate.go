@@ -0,0 +1,412 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+	"gopkg.in/yaml.v2"
+)
+
+var planNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+var yamlLineRegexp = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// ValidationError is a plan problem, Line is 0 when the position is unknown
+type ValidationError struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	msg := e.Message
+	if e.Field != "" {
+		msg = fmt.Sprintf("%v: %v", e.Field, e.Message)
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("line %v: %v", e.Line, msg)
+	}
+	return msg
+}
+
+// ValidationErrors collects all the problems found in a plan
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidName checks that a plan name can be used as a file name and URL param
+func ValidName(name string) error {
+	if !planNameRegexp.MatchString(name) {
+		return errors.Errorf("Invalid plan name %v, only letters, digits, '-' and '_' are allowed", name)
+	}
+	if name == DefaultsFile {
+		return errors.Errorf("Invalid plan name %v, the name is reserved for the defaults file", name)
+	}
+
+	return nil
+}
+
+// Validate checks the plan can be scheduled and every configured
+// destination and notifier has the settings it needs
+func (p Plan) Validate() error {
+	errs := p.validate()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (p Plan) validate() ValidationErrors {
+	errs := ValidationErrors{}
+	add := func(field string, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if err := ValidName(p.Name); err != nil {
+		add("", "%v", err)
+	}
+
+	if p.Scheduler.Cron == "" {
+		add("scheduler.cron", "is required")
+	} else if _, err := cron.ParseStandard(p.Scheduler.Cron); err != nil {
+		add("scheduler.cron", "invalid cron %q %v", p.Scheduler.Cron, err)
+	}
+	if p.Scheduler.Retention < 0 {
+		add("scheduler.retention", "must be zero or positive")
+	}
+	if p.Scheduler.Timeout < 0 {
+		add("scheduler.timeout", "must be zero or positive")
+	}
+	if p.Scheduler.MaxAge < 0 {
+		add("scheduler.maxAge", "must be zero or positive")
+	}
+	if a := p.Anomaly; a != nil {
+		if a.SizeChange < 0 {
+			add("anomaly.sizeChange", "must be zero or positive")
+		}
+		if a.CountChange < 0 {
+			add("anomaly.countChange", "must be zero or positive")
+		}
+		if a.History < 0 {
+			add("anomaly.history", "must be zero or positive")
+		}
+	}
+
+	host := p.Target.Backup.Host
+	hasURI := p.Target.Backup.URI != ""
+	switch p.Target.Topology() {
+	case "standalone":
+		if !hasURI && len(host.Mongod) != 1 {
+			add("target.backup.host.mongod", "standalone requires exactly one host")
+		}
+	case "replicaset":
+		if !hasURI && len(host.Mongod) < 1 {
+			add("target.backup.host.mongod", "replicaset requires at least one member")
+		}
+	case "sharding":
+		if hasURI {
+			break
+		}
+		// the shards are dumped through the routers
+		if len(host.Mongos) < 1 {
+			add("target.backup.host.mongos", "sharding requires at least one mongos router")
+		}
+	case "":
+		if hasURI {
+			add("target.type", "can't be guessed from the uri, set it to standalone, replicaset or sharding")
+		} else {
+			add("target.type", "is required, valid types are standalone, replicaset and sharding")
+		}
+	default:
+		add("target.type", "unknown type %q, valid types are standalone, replicaset and sharding", p.Target.Type)
+	}
+	if m := p.Target.Backup.Member; m != nil {
+		if p.Target.Topology() != "replicaset" {
+			add("target.backup.member", "is only supported by the replicaset type")
+		}
+		if m.MaxLag < 0 {
+			add("target.backup.member.maxLag", "must be zero or positive")
+		}
+		switch m.OnLag {
+		case "", OnLagFail, OnLagSkip:
+		default:
+			add("target.backup.member.onLag", "unknown policy %q, valid policies are %v and %v", m.OnLag, OnLagFail, OnLagSkip)
+		}
+	}
+	if b := p.Target.Backup.Balancer; b != nil {
+		if p.Target.Topology() != "sharding" {
+			add("target.backup.balancer", "is only supported by the sharding type")
+		}
+		if b.Timeout < 0 {
+			add("target.backup.balancer.timeout", "must be zero or positive")
+		}
+		if b.Window != nil {
+			if _, _, err := b.Window.parse(); err != nil {
+				add("target.backup.balancer.window", "%v", err)
+			}
+		}
+	}
+	if p.Target.Backup.Consistent {
+		if p.Target.Topology() != "sharding" {
+			add("target.backup.consistent", "is only supported by the sharding type")
+		}
+		if p.Target.Backup.Database != "" {
+			add("target.backup.consistent", "requires a full dump, database must be empty")
+		}
+	}
+	if p.Target.Backup.Filtered() {
+		if p.Target.Topology() == "sharding" {
+			add("target.backup", "databases and collections are not supported by the sharding type")
+		}
+		if p.Target.Backup.Database != "" && len(p.Target.Backup.Databases) > 0 {
+			add("target.backup.databases", "can't be used together with database")
+		}
+		if p.Target.Backup.Collections != nil {
+			validateCollections(p.Target.Backup.Collections, add)
+		}
+	}
+	if p.Target.Backup.PerDatabase != nil {
+		if p.Target.Topology() == "sharding" {
+			add("target.backup.perDatabase", "is not supported by the sharding type")
+		}
+		if p.Target.Backup.Database != "" || p.Target.Backup.Filtered() {
+			add("target.backup.perDatabase", "can't be used together with database, databases or collections")
+		}
+		validatePerDatabase(p.Target.Backup.PerDatabase, add)
+	}
+	if b := p.Target.Backup; b.UsersAndRoles || b.Admin {
+		restricted := b.Conn().Database != "" || b.Filtered() || b.PerDatabase != nil
+		if b.UsersAndRoles && !restricted {
+			add("target.backup.usersAndRoles", "requires database, databases, collections or perDatabase, a full dump already includes the users and roles")
+		}
+		if b.Admin && !restricted {
+			add("target.backup.admin", "requires database, databases, collections or perDatabase, a full dump already includes the admin database")
+		}
+		if b.Admin && p.Target.Topology() == "sharding" {
+			add("target.backup.admin", "is not supported by the sharding type")
+		}
+	}
+	if len(p.Restore.Shards) > 0 && p.Target.Topology() != "sharding" {
+		add("restore.shards", "is only supported by the sharding type")
+	}
+	targets := make(map[string]string, len(p.Restore.Shards))
+	for src, dst := range p.Restore.Shards {
+		if dst == "" {
+			add("restore.shards."+src, "target shard name is required")
+		} else if prev, ok := targets[dst]; ok {
+			add("restore.shards."+src, "shard %v is already the target of %v", dst, prev)
+		}
+		targets[dst] = src
+	}
+	validateURI("target.backup", p.Target.Backup.URI, p.Target.Backup.Database, p.Target.Backup.Host, add)
+	validateURI("target.restore", p.Target.Restore.URI, p.Target.Restore.Database, p.Target.Restore.Host, add)
+	validateURI("restore", p.Restore.URI, p.Restore.Database, p.Restore.Host, add)
+	validateConn("target.backup", p.Target.Backup.Conn(), add)
+	validateConn("target.restore", p.Target.Restore.Conn(), add)
+	validateConn("restore", p.Restore.Conn(), add)
+
+	if p.S3 != nil {
+		required := map[string]string{
+			"url":       p.S3.URL,
+			"bucket":    p.S3.Bucket,
+			"accessKey": p.S3.AccessKey,
+			"secretKey": p.S3.SecretKey,
+		}
+		for _, key := range []string{"url", "bucket", "accessKey", "secretKey"} {
+			if required[key] == "" {
+				add("s3."+key, "is required")
+			}
+		}
+	}
+
+	if p.SFTP != nil {
+		if p.SFTP.Host == "" {
+			add("sftp.host", "is required")
+		}
+		if p.SFTP.Port < 1 || p.SFTP.Port > 65535 {
+			add("sftp.port", "must be between 1 and 65535")
+		}
+		if p.SFTP.Username == "" {
+			add("sftp.username", "is required")
+		}
+		if p.SFTP.Password == "" {
+			add("sftp.password", "is required")
+		}
+		if p.SFTP.BackupDir == "" {
+			add("sftp.backupdir", "is required")
+		}
+	}
+
+	if p.SMTP != nil {
+		if p.SMTP.Server == "" {
+			add("smtp.server", "is required")
+		}
+		if port, err := strconv.Atoi(p.SMTP.Port); err != nil || port < 1 || port > 65535 {
+			add("smtp.port", "invalid port %q, must be a number between 1 and 65535", p.SMTP.Port)
+		}
+		if p.SMTP.From == "" {
+			add("smtp.from", "is required")
+		}
+		if len(p.SMTP.To) < 1 {
+			add("smtp.to", "requires at least one recipient")
+		}
+	}
+
+	if p.Slack != nil {
+		if p.Slack.URL == "" {
+			add("slack.url", "is required")
+		}
+	}
+
+	return errs
+}
+
+func validateConn(field string, conn Connection, add func(field string, format string, args ...interface{})) {
+	switch conn.AuthMechanism {
+	case "", AuthScramSHA1, AuthScramSHA256, AuthPlain:
+		if (conn.Username == "") != (conn.Password == "") {
+			add(field, "username and password must be set together")
+		}
+	case AuthX509:
+		if conn.TLS == nil || conn.TLS.CertFile == "" {
+			add(field+".tls.certFile", "%v requires a client certificate", AuthX509)
+		}
+		if conn.Password != "" {
+			add(field+".password", "must be empty with %v", AuthX509)
+		}
+	default:
+		add(field+".authMechanism", "unknown mechanism %q, valid mechanisms are %v, %v, %v and %v",
+			conn.AuthMechanism, AuthScramSHA1, AuthScramSHA256, AuthX509, AuthPlain)
+	}
+
+	// the certificate and the key can share a single PEM file
+	if conn.TLS != nil && conn.TLS.KeyFile != "" && conn.TLS.CertFile == "" {
+		add(field+".tls.keyFile", "requires certFile")
+	}
+}
+
+func validateURI(field string, uri string, database string, host Host, add func(field string, format string, args ...interface{})) {
+	if uri == "" {
+		return
+	}
+	u, err := ParseURI(uri)
+	if err != nil {
+		add(field+".uri", "invalid connection string, %v", err)
+		return
+	}
+	if len(host.Mongod) > 0 || len(host.Mongos) > 0 || len(host.Mongoc) > 0 {
+		add(field+".host", "can't be used together with uri")
+	}
+	if u.Database != "" && database != "" && u.Database != database {
+		add(field+".database", "%q doesn't match the uri database %q", database, u.Database)
+	}
+}
+
+// ValidatePlan checks a plan document before it's saved to dir as name, the plan is
+// merged with its parents and the defaults found in dir and then resolved
+func ValidatePlan(dir string, name string, data []byte) error {
+	planPath, err := planFilePath(dir, name)
+	if err != nil {
+		return err
+	}
+
+	if _, errs := loadPlan(planPath, data); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ValidatePlanFile strictly parses, resolves and validates a plan file,
+// the returned errors point to the offending line when possible
+func ValidatePlanFile(path string) ValidationErrors {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ValidationErrors{{Message: err.Error()}}
+	}
+
+	_, errs := loadPlan(path, data)
+	return errs
+}
+
+func parsePlan(path string, data []byte) (Plan, ValidationErrors) {
+	var plan Plan
+	if err := yaml.UnmarshalStrict(data, &plan); err != nil {
+		errs := ValidationErrors{}
+		if terr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range terr.Errors {
+				errs = append(errs, yamlError(msg))
+			}
+		} else {
+			errs = append(errs, yamlError(strings.TrimPrefix(err.Error(), "yaml: ")))
+		}
+		return plan, errs
+	}
+	plan.Name = planName(path)
+
+	return plan, nil
+}
+
+func yamlError(msg string) ValidationError {
+	m := yamlLineRegexp.FindStringSubmatch(msg)
+	if m == nil {
+		return ValidationError{Message: msg}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return ValidationError{Line: line, Message: m[2]}
+}
+
+// lineOf finds a dotted key path in a block style YAML document.
+// When the path is incomplete it returns the line of the deepest parent found,
+// 0 means not even the top level key was found.
+func lineOf(data []byte, path string) int {
+	if path == "" {
+		return 0
+	}
+	keys := strings.Split(path, ".")
+	found := 0
+	depth := 0
+	parentIndent := -1
+	childIndent := -1
+
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		if indent <= parentIndent {
+			// left the parent block without finding the key
+			break
+		}
+		if childIndent < 0 {
+			childIndent = indent
+		}
+		if indent != childIndent {
+			continue
+		}
+		if strings.HasPrefix(trimmed, keys[depth]+":") {
+			found = i + 1
+			depth++
+			if depth == len(keys) {
+				return found
+			}
+			parentIndent = indent
+			childIndent = -1
+		}
+	}
+
+	return found
+}
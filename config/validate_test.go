@@ -0,0 +1,167 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testPlan = `scheduler:
+  cron: "0 6 * * *"
+  retention: 7
+target:
+  type: standalone
+  backup:
+    host:
+      mongod:
+        - "mongo.local:27017"
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		plan   string
+		fields []string
+		lines  []int
+	}{
+		{
+			name: "valid standalone",
+			plan: testPlan,
+		},
+		{
+			name:   "missing cron",
+			plan:   "scheduler:\n  retention: 7\ntarget:\n  type: standalone\n  backup:\n    host:\n      mongod:\n        - \"mongo.local:27017\"\n",
+			fields: []string{"scheduler.cron"},
+			lines:  []int{1},
+		},
+		{
+			name:   "invalid cron",
+			plan:   "scheduler:\n  cron: \"0 25 * * *\"\ntarget:\n  type: standalone\n  backup:\n    host:\n      mongod:\n        - \"mongo.local:27017\"\n",
+			fields: []string{"scheduler.cron"},
+			lines:  []int{2},
+		},
+		{
+			name:   "standalone with two hosts",
+			plan:   testPlan + "        - \"mongo2.local:27017\"\n",
+			fields: []string{"target.backup.host.mongod"},
+			lines:  []int{8},
+		},
+		{
+			name:   "unknown type",
+			plan:   "scheduler:\n  cron: \"0 6 * * *\"\ntarget:\n  type: cluster\n",
+			fields: []string{"target.type"},
+			lines:  []int{4},
+		},
+		{
+			name:   "sharding without routers",
+			plan:   "scheduler:\n  cron: \"0 6 * * *\"\ntarget:\n  type: sharding\n",
+			fields: []string{"target.backup.host.mongos"},
+		},
+		{
+			name:   "sharding through the uri",
+			plan:   "scheduler:\n  cron: \"0 6 * * *\"\ntarget:\n  type: sharding\n  backup:\n    uri: \"mongodb://mongos.local:27017\"\n",
+			fields: nil,
+		},
+		{
+			name:   "uri and hosts",
+			plan:   testPlan + "    uri: \"mongodb://mongo.local:27017\"\n",
+			fields: []string{"target.backup.host"},
+		},
+		{
+			name:   "incomplete S3",
+			plan:   testPlan + "s3:\n  url: \"https://s3.local\"\n  bucket: backup\n",
+			fields: []string{"s3.accessKey", "s3.secretKey"},
+		},
+		{
+			name:   "invalid SMTP port",
+			plan:   testPlan + "smtp:\n  server: smtp.local\n  port: smtp\n  from: mgob@local\n  to:\n    - ops@local\n",
+			fields: []string{"smtp.port"},
+			lines:  []int{12},
+		},
+		{
+			name:   "incomplete SFTP",
+			plan:   testPlan + "sftp:\n  host: sftp.local\n  port: 70000\n  username: mgob\n",
+			fields: []string{"sftp.backupdir", "sftp.password", "sftp.port"},
+		},
+		{
+			name: "SCRAM-SHA-256",
+			plan: testPlan + "    authMechanism: SCRAM-SHA-256\n    username: admin\n    password: secret\n",
+		},
+		{
+			name:   "unknown mechanism",
+			plan:   testPlan + "    authMechanism: GSSAPI\n",
+			fields: []string{"target.backup.authMechanism"},
+		},
+		{
+			name: "x509 with a combined PEM file",
+			plan: testPlan + "    authMechanism: MONGODB-X509\n    tls:\n      certFile: /certs/client.pem\n",
+		},
+		{
+			name:   "key without a certificate",
+			plan:   testPlan + "    tls:\n      keyFile: /certs/client.key\n",
+			fields: []string{"target.backup.tls.keyFile"},
+		},
+		{
+			name:   "x509 without a certificate",
+			plan:   testPlan + "    authMechanism: MONGODB-X509\n",
+			fields: []string{"target.backup.tls.certFile"},
+		},
+		{
+			name:   "unknown field",
+			plan:   testPlan + "slak:\n  url: \"https://hooks.slack.local\"\n",
+			fields: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "mgob-config-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			_, errs := loadPlan(filepath.Join(dir, "plan.yml"), []byte(tt.plan))
+			fields := make([]string, 0, len(errs))
+			lines := make([]int, 0, len(errs))
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+				lines = append(lines, e.Line)
+			}
+			sort.Strings(fields)
+			want := tt.fields
+			if want == nil {
+				want = []string{}
+			}
+			if !reflect.DeepEqual(fields, want) {
+				t.Fatalf("fields %v, want %v: %v", fields, want, errs)
+			}
+			if tt.lines != nil && !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("lines %v, want %v: %v", lines, tt.lines, errs)
+			}
+		})
+	}
+}
+
+func TestValidName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"mongo-prod", true},
+		{"mongo_prod2", true},
+		{"-mongo", false},
+		{"mongo/prod", false},
+		{"../mongo", false},
+		{DefaultsFile, false},
+	}
+
+	for _, tt := range tests {
+		if err := ValidName(tt.name); (err == nil) != tt.valid {
+			t.Errorf("ValidName(%q) = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+//go:build linux
+// +build linux
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = uint32(unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO |
+	unix.IN_MOVED_FROM | unix.IN_DELETE | unix.IN_DELETE_SELF)
+
+// Watch sends on changes every time a file is created, written, moved or
+// removed inside dir or one of its subdirs, the plans are read recursively.
+// Sends are non-blocking so a burst of events is coalesced into a single
+// pending notification.
+func Watch(dir string, changes chan<- struct{}) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return errors.Wrap(err, "inotify init failed")
+	}
+
+	dirs := make(map[int]string)
+	if err := watchTree(fd, dir, dirs); err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, unix.SizeofInotifyEvent*64+unix.PathMax)
+		for {
+			n, err := unix.Read(fd, buf)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				logrus.Errorf("Watching %v stopped %v", dir, err)
+				return
+			}
+
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + unix.SizeofInotifyEvent
+				offset = nameStart + int(event.Len)
+
+				switch {
+				case event.Mask&unix.IN_IGNORED != 0:
+					delete(dirs, int(event.Wd))
+				case event.Mask&unix.IN_ISDIR != 0 && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+					// a new subdir may already hold plans when it's watched
+					parent, ok := dirs[int(event.Wd)]
+					if !ok {
+						continue
+					}
+					name := strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+					if err := watchTree(fd, filepath.Join(parent, name), dirs); err != nil {
+						logrus.Errorf("Watching %v failed %v", filepath.Join(parent, name), err)
+					}
+				}
+			}
+
+			if n > 0 {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchTree adds a watch on dir and every dir below it
+func watchTree(fd int, dir string, dirs map[int]string) error {
+	return filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			// a subdir removed while walking
+			if path != dir && os.IsNotExist(err) {
+				return nil
+			}
+			return errors.Wrapf(err, "inotify watch %v failed", path)
+		}
+		if !f.IsDir() {
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(fd, path, watchMask)
+		if err != nil {
+			return errors.Wrapf(err, "inotify watch %v failed", path)
+		}
+		dirs[wd] = path
+		return nil
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package config
+
+import "github.com/pkg/errors"
+
+// Watch is only supported on linux, use SIGHUP to reload plans
+func Watch(dir string, changes chan<- struct{}) error {
+	return errors.New("watching the config dir is not supported on this platform")
+}
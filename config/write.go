@@ -0,0 +1,83 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// WritePlan saves the plan document to dir, replacing the existing plan file if any.
+// Only the submitted keys are written, the others keep being inherited from the defaults
+// and the extended plan. The file is written to a temporary file first and renamed,
+// so a concurrent reload never reads a partial plan.
+func WritePlan(dir string, name string, data []byte) (string, error) {
+	planPath, err := planFilePath(dir, name)
+	if err != nil {
+		return "", err
+	}
+
+	// JSON documents are stored as YAML, the key order is kept
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", errors.Wrapf(err, "Parsing plan %v failed", name)
+	}
+	data, err = yaml.Marshal(doc)
+	if err != nil {
+		return "", errors.Wrapf(err, "Marshaling plan %v failed", name)
+	}
+
+	// the tmp file name must not end in yml, otherwise it will be picked up as a plan
+	tmp, err := ioutil.TempFile(filepath.Dir(planPath), ".mgob-")
+	if err != nil {
+		return "", errors.Wrapf(err, "Creating tmp file in %v failed", dir)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", errors.Wrapf(err, "Writing %v failed", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", errors.Wrapf(err, "Syncing %v failed", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return "", errors.Wrapf(err, "Closing %v failed", tmp.Name())
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", errors.Wrapf(err, "Chmod %v failed", tmp.Name())
+	}
+
+	if err := os.Rename(tmp.Name(), planPath); err != nil {
+		return "", errors.Wrapf(err, "Renaming %v to %v failed", tmp.Name(), planPath)
+	}
+
+	return planPath, nil
+}
+
+// planFilePath returns the path of the existing plan file or the path of a new one
+func planFilePath(dir string, name string) (string, error) {
+	planPath, err := PlanPath(dir, name)
+	if errors.Cause(err) == ErrPlanNotFound {
+		return filepath.Join(dir, name+".yml"), nil
+	}
+
+	return planPath, err
+}
+
+// DeletePlan removes the plan file from dir
+func DeletePlan(dir string, name string) error {
+	planPath, err := PlanPath(dir, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(planPath); err != nil {
+		return errors.Wrapf(err, "Removing %v failed", planPath)
+	}
+
+	return nil
+}
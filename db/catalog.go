@@ -0,0 +1,222 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/pkg/errors"
+)
+
+// Backup is a catalog record, one per stored archive
+type Backup struct {
+	// ID is plan/archive, archive names are unique within a plan
+	ID        string    `json:"id"`
+	Plan      string    `json:"plan"`
+	RunID     string    `json:"run_id"`
+	Archive   string    `json:"archive"`
+	Timestamp time.Time `json:"timestamp"`
+	Status    int       `json:"status"`
+	Size      int64     `json:"size"`
+	// Checksum is the sha256 of the archive
+	Checksum   string `json:"checksum,omitempty"`
+	Codec      string `json:"codec"`
+	Encryption string `json:"encryption"`
+	// Database is set on the archives of a perDatabase plan
+	Database         string   `json:"database,omitempty"`
+	Databases        []string `json:"databases,omitempty"`
+	MongodumpVersion string   `json:"mongodump_version,omitempty"`
+	ServerVersion    string   `json:"server_version,omitempty"`
+	Copies           []Copy   `json:"copies"`
+}
+
+// Copy is the archive stored in a destination, Key is the storage relative path,
+// the SFTP path or the S3 bucket/object
+type Copy struct {
+	Destination string `json:"destination"`
+	Key         string `json:"key"`
+}
+
+// Copy returns the copy stored in destination, if any
+func (b *Backup) Copy(destination string) (Copy, bool) {
+	for _, c := range b.Copies {
+		if c.Destination == destination {
+			return c, true
+		}
+	}
+	return Copy{}, false
+}
+
+// CatalogFilter selects catalog records, zero values match everything
+type CatalogFilter struct {
+	Plan        string
+	Database    string
+	Status      int
+	Destination string
+	Since       time.Time
+	Until       time.Time
+	// Limit keeps the newest records only
+	Limit int
+}
+
+// Matches reports whether b is selected by the filter
+func (f CatalogFilter) Matches(b *Backup) bool {
+	if f.Plan != "" && b.Plan != f.Plan {
+		return false
+	}
+	if f.Database != "" && b.Database != f.Database && !contains(b.Databases, f.Database) {
+		return false
+	}
+	if f.Status != 0 && b.Status != f.Status {
+		return false
+	}
+	if f.Destination != "" {
+		if _, ok := b.Copy(f.Destination); !ok {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && b.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && b.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+type CatalogStore struct {
+	*Store
+	bucket []byte
+}
+
+// NewCatalogStore creates bucket if not found
+func NewCatalogStore(store *Store) (*CatalogStore, error) {
+	bucket := []byte("catalog")
+
+	err := store.NewBucket(bucket)
+	if err != nil {
+		return nil, errors.Wrap(err, "Catalog store bucket init failed")
+	}
+
+	return &CatalogStore{store, bucket}, nil
+}
+
+// CatalogID returns the record ID of an archive
+func CatalogID(plan string, archive string) string {
+	return plan + "/" + archive
+}
+
+// Put upserts a catalog record
+func (db *CatalogStore) Put(backup *Backup) error {
+	backup.ID = CatalogID(backup.Plan, backup.Archive)
+	buf, err := json.Marshal(backup)
+	if err != nil {
+		return errors.Wrap(err, "Catalog store json marshal failed")
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.bucket).Put([]byte(backup.ID), buf)
+	})
+}
+
+// Get loads the record of a plan archive, nil when not found
+func (db *CatalogStore) Get(plan string, archive string) (*Backup, error) {
+	var backup *Backup
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(db.bucket).Get([]byte(CatalogID(plan, archive)))
+		if v == nil {
+			return nil
+		}
+		backup = &Backup{}
+		if err := json.Unmarshal(v, backup); err != nil {
+			return errors.Wrap(err, "Catalog store json unmarshal failed")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return backup, nil
+}
+
+// List returns the records selected by the filter, newest first
+func (db *CatalogStore) List(filter CatalogFilter) ([]*Backup, error) {
+	backups := make([]*Backup, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(db.bucket).Cursor()
+
+		// the plan records share the plan/ key prefix
+		prefix := []byte{}
+		if filter.Plan != "" {
+			prefix = []byte(filter.Plan + "/")
+		}
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var backup Backup
+			if err := json.Unmarshal(v, &backup); err != nil {
+				return errors.Wrap(err, "Catalog store json unmarshal failed")
+			}
+			if filter.Matches(&backup) {
+				backups = append(backups, &backup)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Timestamp.After(backups[j].Timestamp) })
+	if filter.Limit > 0 && len(backups) > filter.Limit {
+		backups = backups[:filter.Limit]
+	}
+
+	return backups, nil
+}
+
+// RemoveCopy drops the destination copy of an archive, the record is deleted
+// when no copy is left
+func (db *CatalogStore) RemoveCopy(plan string, archive string, destination string) error {
+	id := []byte(CatalogID(plan, archive))
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.bucket)
+		v := b.Get(id)
+		if v == nil {
+			return nil
+		}
+		var backup Backup
+		if err := json.Unmarshal(v, &backup); err != nil {
+			return errors.Wrap(err, "Catalog store json unmarshal failed")
+		}
+
+		copies := make([]Copy, 0, len(backup.Copies))
+		for _, c := range backup.Copies {
+			if c.Destination != destination {
+				copies = append(copies, c)
+			}
+		}
+		if len(copies) == 0 {
+			return b.Delete(id)
+		}
+		backup.Copies = copies
+
+		buf, err := json.Marshal(backup)
+		if err != nil {
+			return errors.Wrap(err, "Catalog store json marshal failed")
+		}
+		return b.Put(id, buf)
+	})
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
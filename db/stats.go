@@ -14,24 +14,46 @@ type Status struct {
 	NextRun       time.Time  `json:"next_run"`
 	LastRun       *time.Time `json:"last_run,omitempty"`
 	LastRunStatus string     `json:"last_run_status,omitempty"`
-	LastRunLog    string     `json:"last_run_log,omitempty"`
+	// LastSuccess is the start of the last run that stored an archive
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastRunLog  string     `json:"last_run_log,omitempty"`
+	// LastRunMember is the replica set member the last backup was taken from
+	LastRunMember string `json:"last_run_member,omitempty"`
+	// LastRunAnomalies lists why the last run is suspicious
+	LastRunAnomalies []string `json:"last_run_anomalies,omitempty"`
+}
+
+// Sample is the size and the collections of a successful run, kept to detect the anomalies
+type Sample struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Archive     string    `json:"archive"`
+	Size        int64     `json:"size"`
+	Collections []string  `json:"collections"`
+	// Counts holds the document count of each collection when the documents were counted
+	Counts map[string]int64 `json:"counts,omitempty"`
 }
 
 type StatusStore struct {
 	*Store
-	bucket []byte
+	bucket  []byte
+	history []byte
 }
 
-// NewStatusStore creates bucket if not found
+// NewStatusStore creates buckets if not found
 func NewStatusStore(store *Store) (*StatusStore, error) {
 	bucket := []byte("scheduler_status")
+	history := []byte("scheduler_history")
 
 	err := store.NewBucket(bucket)
 	if err != nil {
 		return nil, errors.Wrap(err, "Status store bucket init failed")
 	}
+	err = store.NewBucket(history)
+	if err != nil {
+		return nil, errors.Wrap(err, "Status store history bucket init failed")
+	}
 
-	return &StatusStore{store, bucket}, nil
+	return &StatusStore{store, bucket, history}, nil
 }
 
 // Put upserts job status
@@ -48,6 +70,27 @@ func (db *StatusStore) Put(status *Status) error {
 	})
 }
 
+// Get loads the status of a plan, nil when not found
+func (db *StatusStore) Get(plan string) (*Status, error) {
+	var status *Status
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(db.bucket).Get([]byte(plan))
+		if v == nil {
+			return nil
+		}
+		status = &Status{}
+		if err := json.Unmarshal(v, status); err != nil {
+			return errors.Wrap(err, "Status store json unmarshal failed")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
 // Sync plans found on disk with db
 func (db *StatusStore) Sync(stats []*Status) error {
 	return db.Update(func(tx *bolt.Tx) error {
@@ -118,6 +161,10 @@ func (db *StatusStore) Sync(stats []*Status) error {
 				if err != nil {
 					return errors.Wrapf(err, "Removing %v from store failed", oldS.Plan)
 				}
+				err = tx.Bucket(db.history).Delete([]byte(oldS.Plan))
+				if err != nil {
+					return errors.Wrapf(err, "Removing %v history from store failed", oldS.Plan)
+				}
 			}
 		}
 
@@ -150,3 +197,49 @@ func (db *StatusStore) GetAll() ([]*Status, error) {
 
 	return stats, nil
 }
+
+// History loads the samples of a plan, oldest first
+func (db *StatusStore) History(plan string) ([]Sample, error) {
+	samples := make([]Sample, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(db.history).Get([]byte(plan))
+		if v == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &samples); err != nil {
+			return errors.Wrap(err, "Status store json unmarshal failed")
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// AddSample appends a sample to the plan history, only the newest keep samples are kept
+func (db *StatusStore) AddSample(plan string, sample Sample, keep int) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.history)
+
+		samples := make([]Sample, 0)
+		if v := b.Get([]byte(plan)); v != nil {
+			if err := json.Unmarshal(v, &samples); err != nil {
+				return errors.Wrap(err, "Status store json unmarshal failed")
+			}
+		}
+		samples = append(samples, sample)
+		if len(samples) > keep {
+			samples = samples[len(samples)-keep:]
+		}
+
+		buf, err := json.Marshal(samples)
+		if err != nil {
+			return errors.Wrap(err, "Status store json marshal failed")
+		}
+		return b.Put([]byte(plan), buf)
+	})
+}
@@ -1,24 +1,41 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/dustin/go-humanize"
 	"github.com/vtomasr5/mgob/api"
+	"github.com/vtomasr5/mgob/archive"
 	"github.com/vtomasr5/mgob/backup"
 	"github.com/vtomasr5/mgob/config"
 	"github.com/vtomasr5/mgob/db"
+	"github.com/vtomasr5/mgob/redact"
 	"github.com/vtomasr5/mgob/scheduler"
 )
 
 var version = "master~HEAD"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "catalog" {
+		os.Exit(catalog(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(diff(os.Args[2:]))
+	}
+
 	var appConfig = &config.AppConfig{}
 	flag.StringVar(&appConfig.LogLevel, "LogLevel", "debug", "logging threshold level: debug|info|warn|error|fatal|panic")
 	flag.IntVar(&appConfig.Port, "Port", 8090, "HTTP port to listen on")
@@ -28,6 +45,7 @@ func main() {
 	flag.StringVar(&appConfig.DataPath, "DataPath", "/data", "db dir")
 	flag.Parse()
 	setLogLevel(appConfig.LogLevel)
+	logrus.AddHook(redact.Hook{})
 	logrus.Infof("Starting with config: %+v", appConfig)
 
 	info, err := backup.CheckMongodump()
@@ -36,6 +54,13 @@ func main() {
 	}
 	logrus.Info(info)
 
+	// mongorestore is only needed by the sharded restore
+	if info, err := backup.CheckMongorestore(); err != nil {
+		logrus.Warnf("Restore disabled %v", err)
+	} else {
+		logrus.Info(info)
+	}
+
 	info, err = backup.CheckMinioClient()
 	if err != nil {
 		logrus.Fatal(err)
@@ -55,12 +80,44 @@ func main() {
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	sch := scheduler.New(plans, appConfig, statusStore)
+	catalogStore, err := db.NewCatalogStore(store)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	sch := scheduler.New(plans, appConfig, statusStore, catalogStore)
 	sch.Start()
 
+	// reload plans on config dir changes or SIGHUP
+	reload := make(chan struct{}, 1)
+	if err := config.Watch(filepath.Clean(appConfig.ConfigPath), reload); err != nil {
+		logrus.Warnf("Config dir watch disabled, use SIGHUP to reload plans: %v", err)
+	}
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-reload:
+				// wait for editors and config map updates to settle
+				time.Sleep(2 * time.Second)
+				select {
+				case <-reload:
+				default:
+				}
+			case <-hupChan:
+				logrus.Info("SIGHUP received, reloading plans")
+			}
+			if err := sch.Reload(); err != nil {
+				logrus.Errorf("Reloading plans failed %v", err)
+			}
+		}
+	}()
+
 	server := &api.HttpServer{
-		Config: appConfig,
-		Stats:  statusStore,
+		Config:    appConfig,
+		Stats:     statusStore,
+		Catalog:   catalogStore,
+		Scheduler: sch,
 	}
 	logrus.Infof("Starting HTTP server on port %v", appConfig.Port)
 	go server.Start(version)
@@ -71,6 +128,168 @@ func main() {
 	sig := <-sigChan
 
 	logrus.Infof("Shutting down %v signal received", sig)
+	backup.RestartBalancers()
+}
+
+// validate checks every plan found in ConfigPath and prints the errors as file:line: message
+func validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("ConfigPath", "/config", "plan yml files dir")
+	fs.Parse(args)
+
+	files, err := config.PlanFiles(filepath.Clean(*configPath))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(files) < 1 {
+		fmt.Fprintf(os.Stderr, "No backup plans found in %v\n", *configPath)
+		return 2
+	}
+
+	code := 0
+	for _, file := range files {
+		errs := config.ValidatePlanFile(file)
+		for _, e := range errs {
+			line := e.Line
+			e.Line = 0
+			if line > 0 {
+				fmt.Printf("%v:%v: %v\n", file, line, e)
+			} else {
+				fmt.Printf("%v: %v\n", file, e)
+			}
+		}
+		if len(errs) > 0 {
+			code = 1
+		}
+	}
+	if code == 0 {
+		fmt.Printf("Checked %v plans, no errors found\n", len(files))
+	}
+
+	return code
+}
+
+// catalog runs the catalog subcommands, rescan rebuilds the catalog from the stored archives.
+// The store is locked by a running mgob, use the API instead.
+func catalog(args []string) int {
+	if len(args) < 1 || args[0] != "rescan" {
+		fmt.Fprintln(os.Stderr, "Usage: mgob catalog rescan [-ConfigPath /config] [-StoragePath /storage] [-DataPath /data] [-Plan planID]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("catalog rescan", flag.ExitOnError)
+	configPath := fs.String("ConfigPath", "/config", "plan yml files dir")
+	storagePath := fs.String("StoragePath", "/storage", "backup storage")
+	dataPath := fs.String("DataPath", "/data", "db dir")
+	planID := fs.String("Plan", "", "rescan a single plan")
+	fs.Parse(args[1:])
+
+	// every plan is loaded, the archive names of the other plans must be told apart
+	plans, err := config.LoadPlans(filepath.Clean(*configPath))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	store, err := db.Open(path.Join(filepath.Clean(*dataPath), "mgob.db"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer store.Close()
+	catalogStore, err := db.NewCatalogStore(store)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report, err := backup.Rescan(plans, *planID, filepath.Clean(*storagePath), catalogStore)
+	code := 0
+	for _, p := range report.Plans {
+		fmt.Printf("%v: %v archives, %v added, %v updated, %v removed\n", p.Plan, p.Archives, p.Added, p.Updated, p.Removed)
+		for _, name := range p.Orphans {
+			fmt.Printf("%v: orphan %v has no local copy\n", p.Plan, name)
+		}
+		for _, m := range p.Missing {
+			fmt.Printf("%v: %v missing from %v\n", p.Plan, m.Archive, strings.Join(m.Destinations, ", "))
+		}
+		for dest, e := range p.Errors {
+			fmt.Printf("%v: listing %v failed %v\n", p.Plan, dest, e)
+			code = 1
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, redact.Error(err))
+		return 1
+	}
+
+	return code
+}
+
+// diff compares two backups of a plan, the backups are archive paths or archive names of the plan storage
+func diff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	storagePath := fs.String("StoragePath", "/storage", "backup storage")
+	planID := fs.String("Plan", "", "plan of the archive names")
+	counts := fs.Bool("Counts", true, "count the documents, reads both archives in full")
+	asJSON := fs.Bool("JSON", false, "print the diff as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: mgob diff [-StoragePath /storage] [-Plan planID] [-Counts=false] [-JSON] <from> <to>")
+		return 2
+	}
+
+	paths := make([]string, 0, 2)
+	for _, name := range fs.Args() {
+		p := name
+		if _, err := os.Stat(p); err != nil && *planID != "" {
+			p = backup.LocalPath(filepath.Clean(*storagePath), *planID, name)
+		}
+		if _, err := os.Stat(p); err != nil {
+			fmt.Fprintf(os.Stderr, "Backup %v not found\n", name)
+			return 2
+		}
+		paths = append(paths, p)
+	}
+
+	d, err := archive.DiffFiles(paths[0], paths[1], *counts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, redact.Error(err))
+		return 1
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(d, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+
+	fmt.Printf("%v -> %v: size %v -> %v, %v added, %v removed, %v changed, %v unchanged\n", d.From, d.To,
+		humanize.Bytes(uint64(d.SizeFrom)), humanize.Bytes(uint64(d.SizeTo)), d.Added, d.Removed, d.Changed, d.Unchanged)
+	for _, ns := range d.Namespaces {
+		line := fmt.Sprintf("size %v -> %v", humanize.Bytes(uint64(ns.SizeFrom)), humanize.Bytes(uint64(ns.SizeTo)))
+		if ns.CountDelta != nil {
+			line += fmt.Sprintf(", count %v -> %v (%+d)", countOf(ns.CountFrom), countOf(ns.CountTo), *ns.CountDelta)
+		}
+		for _, idx := range ns.IndexesAdded {
+			line += ", index " + idx + " added"
+		}
+		for _, idx := range ns.IndexesRemoved {
+			line += ", index " + idx + " removed"
+		}
+		for _, idx := range ns.IndexesChanged {
+			line += ", index " + idx + " changed"
+		}
+		fmt.Printf("%v %v: %v\n", ns.Status, ns.Namespace, line)
+	}
+
+	return 0
+}
+
+func countOf(n *int64) string {
+	if n == nil {
+		return "-"
+	}
+	return fmt.Sprint(*n)
 }
 
 func setLogLevel(levelName string) {
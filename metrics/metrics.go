@@ -3,8 +3,24 @@ package metrics
 import "github.com/prometheus/client_golang/prometheus"
 
 type BackupMetrics struct {
-	Total   *prometheus.CounterVec
-	Latency *prometheus.SummaryVec
+	Total       *prometheus.CounterVec
+	Latency     *prometheus.SummaryVec
+	Destination *prometheus.CounterVec
+	Suspicious  *prometheus.GaugeVec
+	Anomalies   *prometheus.CounterVec
+	LastSuccess *prometheus.GaugeVec
+	RPOBreached *prometheus.GaugeVec
+	// Stage is the duration of the dump, compress, local, upload and retention stages,
+	// the destination is set on the upload stage only
+	Stage         *prometheus.HistogramVec
+	ArchiveSize   *prometheus.GaugeVec
+	UploadedBytes *prometheus.CounterVec
+	Retention     *prometheus.CounterVec
+	LastRun       *prometheus.GaugeVec
+	LastRunStatus *prometheus.GaugeVec
+	Running       *prometheus.GaugeVec
+	// NotifierFailures counts the notifications that couldn't be sent
+	NotifierFailures *prometheus.CounterVec
 }
 
 func New(namespace string, subsystem string) *BackupMetrics {
@@ -30,8 +46,152 @@ func New(namespace string, subsystem string) *BackupMetrics {
 		[]string{"plan", "status"},
 	)
 
+	prom.Destination = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "destination_total",
+			Help:      "The total number of archive copies per destination.",
+		},
+		[]string{"plan", "destination", "status"},
+	)
+
+	prom.Suspicious = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "backup_suspicious",
+			Help:      "Whether the last successful backup is suspicious, 1 when it differs too much from the history.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.Anomalies = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "anomaly_total",
+			Help:      "The total number of anomalies found in successful backups.",
+		},
+		[]string{"plan"},
+	)
+
+	// the RPO gauges are named after the namespace only
+	prom.LastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "The start time of the last successful backup.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.RPOBreached = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rpo_breached",
+			Help:      "Whether the last successful backup is older than the plan max age.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.Stage = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "stage_duration_seconds",
+			Help:      "Backup stage duration in seconds.",
+			// 1s up to 4.5h
+			Buckets: prometheus.ExponentialBuckets(1, 2, 15),
+		},
+		[]string{"plan", "stage", "destination"},
+	)
+
+	prom.ArchiveSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "archive_size_bytes",
+			Help:      "The size of the last backup archive, summed over the databases of a perDatabase plan.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.UploadedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "uploaded_bytes_total",
+			Help:      "The total number of archive bytes copied to the remote destinations.",
+		},
+		[]string{"plan", "destination"},
+	)
+
+	prom.Retention = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "retention_deleted_total",
+			Help:      "The total number of archives removed from the local storage by the retention.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.LastRun = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_run_timestamp_seconds",
+			Help:      "The start time of the last backup.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.LastRunStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_run_status",
+			Help:      "The status of the last backup, 200 ok, 206 partial, 204 skipped or 500 failed.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.Running = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "running_jobs",
+			Help:      "The number of running backups.",
+		},
+		[]string{"plan"},
+	)
+
+	prom.NotifierFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "notifier",
+			Name:      "failures_total",
+			Help:      "The total number of notifications that failed to be sent.",
+		},
+		[]string{"plan", "notifier"},
+	)
+
 	prometheus.MustRegister(prom.Total)
 	prometheus.MustRegister(prom.Latency)
+	prometheus.MustRegister(prom.Destination)
+	prometheus.MustRegister(prom.Suspicious)
+	prometheus.MustRegister(prom.Anomalies)
+	prometheus.MustRegister(prom.LastSuccess)
+	prometheus.MustRegister(prom.RPOBreached)
+	prometheus.MustRegister(prom.Stage)
+	prometheus.MustRegister(prom.ArchiveSize)
+	prometheus.MustRegister(prom.UploadedBytes)
+	prometheus.MustRegister(prom.Retention)
+	prometheus.MustRegister(prom.LastRun)
+	prometheus.MustRegister(prom.LastRunStatus)
+	prometheus.MustRegister(prom.Running)
+	prometheus.MustRegister(prom.NotifierFailures)
 
 	return prom
 }
@@ -1,15 +1,36 @@
 package notifier
 
-import "github.com/vtomasr5/mgob/config"
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/redact"
+)
 
-func SendNotification(subject string, body string, warn bool, plan config.Plan) error {
+// Notifier sends the plan notifications and counts the failed ones by plan and notifier
+type Notifier struct {
+	failures *prometheus.CounterVec
+}
+
+func New(failures *prometheus.CounterVec) *Notifier {
+	return &Notifier{failures: failures}
+}
+
+func (n *Notifier) SendNotification(subject string, body string, warn bool, plan config.Plan) error {
+	subject = redact.String(subject)
+	body = redact.String(body)
 
 	var err error
 	if plan.SMTP != nil {
 		err = sendEmailNotification(subject, body, plan.SMTP)
+		if err != nil {
+			n.failures.WithLabelValues(plan.Name, "smtp").Inc()
+		}
 	}
 	if plan.Slack != nil {
 		err = sendSlackNotification(subject, body, warn, plan.Slack)
+		if err != nil {
+			n.failures.WithLabelValues(plan.Name, "slack").Inc()
+		}
 	}
 	return err
 }
@@ -3,6 +3,9 @@ package scheduler
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -14,6 +17,7 @@ import (
 	"github.com/vtomasr5/mgob/db"
 	"github.com/vtomasr5/mgob/metrics"
 	"github.com/vtomasr5/mgob/notifier"
+	"github.com/vtomasr5/mgob/redact"
 )
 
 type Scheduler struct {
@@ -21,28 +25,38 @@ type Scheduler struct {
 	Plans   []config.Plan
 	Config  *config.AppConfig
 	Stats   *db.StatusStore
-	metrics *metrics.BackupMetrics
+	Catalog *db.CatalogStore
+	// Notifier sends the notifications of the scheduled and the on demand runs
+	Notifier *notifier.Notifier
+	metrics  *metrics.BackupMetrics
+	mu       sync.Mutex
 }
 
-func New(plans []config.Plan, conf *config.AppConfig, stats *db.StatusStore) *Scheduler {
+func New(plans []config.Plan, conf *config.AppConfig, stats *db.StatusStore, catalog *db.CatalogStore) *Scheduler {
+	m := metrics.New("mgob", "scheduler")
 	s := &Scheduler{
-		Cron:    cron.New(),
-		Plans:   plans,
-		Config:  conf,
-		Stats:   stats,
-		metrics: metrics.New("mgob", "scheduler"),
+		Cron:     cron.New(),
+		Plans:    plans,
+		Config:   conf,
+		Stats:    stats,
+		Catalog:  catalog,
+		Notifier: notifier.New(m.NotifierFailures),
+		metrics:  m,
 	}
 
 	return s
 }
 
 func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for _, plan := range s.Plans {
 		schedule, err := cron.ParseStandard(plan.Scheduler.Cron)
 		if err != nil {
 			return errors.Wrapf(err, "Invalid cron %v for plan %v", plan.Scheduler.Cron, plan.Name)
 		}
-		s.Cron.Schedule(schedule, backupJob{plan.Name, plan, s.Config, s.Stats, s.metrics, s.Cron})
+		s.Cron.Schedule(schedule, backupJob{plan.Name, plan, s.Config, s.Stats, s.Catalog, s.metrics, s})
 	}
 
 	s.Cron.AddFunc("0 0 */1 * *", func() {
@@ -50,6 +64,96 @@ func (s *Scheduler) Start() error {
 	})
 
 	s.Cron.Start()
+	s.syncStatus()
+	s.initMetrics()
+	go s.watch()
+
+	return nil
+}
+
+// Reload re-reads the plans from the config dir and reschedules them.
+// A plan that fails to load keeps running its previous version,
+// a plan whose file was removed is unscheduled.
+// Running backups are not interrupted.
+func (s *Scheduler) Reload() error {
+	files, err := config.PlanFiles(filepath.Clean(s.Config.ConfigPath))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[string]config.Plan)
+	for _, plan := range s.Plans {
+		current[plan.Name] = plan
+	}
+
+	c := cron.New()
+	plans := make([]config.Plan, 0)
+	for _, path := range files {
+		plan, err := config.LoadPlanFile(path)
+		if err != nil {
+			_, filename := filepath.Split(path)
+			name := strings.TrimSuffix(filename, filepath.Ext(filename))
+			old, found := current[name]
+			if !found {
+				logrus.WithField("plan", name).Errorf("Plan rejected %v", err)
+				continue
+			}
+			logrus.WithField("plan", name).Errorf("Plan rejected, keeping previous version %v", err)
+			plan = old
+		}
+
+		schedule, _ := cron.ParseStandard(plan.Scheduler.Cron)
+		c.Schedule(schedule, backupJob{plan.Name, plan, s.Config, s.Stats, s.Catalog, s.metrics, s})
+		plans = append(plans, plan)
+	}
+
+	c.AddFunc("0 0 */1 * *", func() {
+		backup.TmpCleanup(filepath.Clean(s.Config.TmpPath))
+	})
+
+	// the old entries are stopped first so a plan never fires twice during the swap
+	s.Cron.Stop()
+	s.Cron = c
+	s.Plans = plans
+	c.Start()
+
+	loaded := make(map[string]bool)
+	for _, plan := range plans {
+		loaded[plan.Name] = true
+	}
+	for name := range current {
+		if !loaded[name] {
+			s.forgetMetrics(name)
+		}
+	}
+
+	logrus.Infof("Reloaded %v plans from %v", len(plans), s.Config.ConfigPath)
+	s.syncStatus()
+
+	return nil
+}
+
+// nextRun returns the next scheduled run of a plan
+func (s *Scheduler) nextRun(name string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.Cron.Entries() {
+		switch e.Job.(type) {
+		case backupJob:
+			if e.Job.(backupJob).name == name {
+				return e.Next
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *Scheduler) syncStatus() {
 	stats := make([]*db.Status, 0)
 	for _, e := range s.Cron.Entries() {
 		switch e.Job.(type) {
@@ -67,8 +171,6 @@ func (s *Scheduler) Start() error {
 	if err := s.Stats.Sync(stats); err != nil {
 		logrus.Errorf("Status store sync failed %v", err)
 	}
-
-	return nil
 }
 
 type backupJob struct {
@@ -76,62 +178,250 @@ type backupJob struct {
 	plan    config.Plan
 	conf    *config.AppConfig
 	stats   *db.StatusStore
+	catalog *db.CatalogStore
 	metrics *metrics.BackupMetrics
-	cron    *cron.Cron
+	sch     *Scheduler
 }
 
 func (b backupJob) Run() {
 	logrus.WithField("plan", b.plan.Name).Info("Backup started")
+	defer b.sch.Running(b.plan.Name)()
 	status := "200"
 	log := ""
 	t1 := time.Now()
 
 	res, err := backup.Run(b.plan, filepath.Clean(b.conf.TmpPath), filepath.Clean(b.conf.StoragePath))
-	if err != nil {
+	if errors.Cause(err) == backup.ErrSkipped {
+		status = "204"
+		log = fmt.Sprintf("Backup skipped %v", err)
+		logrus.WithField("plan", b.plan.Name).Warn(log)
+
+		if err := b.sch.Notifier.SendNotification(fmt.Sprintf("%v backup skipped", b.plan.Name),
+			err.Error(), true, b.plan); err != nil {
+			logrus.WithField("plan", b.plan.Name).Errorf("Notifier failed %v", err)
+		}
+	} else if err != nil {
 		status = "500"
 		log = fmt.Sprintf("Backup failed %v", err)
 		logrus.WithField("plan", b.plan.Name).Error(log)
 
-		if err := notifier.SendNotification(fmt.Sprintf("%v backup failed", b.plan.Name),
+		if err := b.sch.Notifier.SendNotification(fmt.Sprintf("%v backup failed", b.plan.Name),
 			err.Error(), true, b.plan); err != nil {
 			logrus.WithField("plan", b.plan.Name).Errorf("Notifier failed %v", err)
 		}
-	} else {
+	}
+
+	// the anomalies are part of the run notification, a suspicious run is never reported as a green one
+	var anomalies []string
+	if err == nil && b.plan.Anomaly != nil {
+		anomalies = b.checkAnomalies(res)
+	}
+	suspicious := ""
+	if len(anomalies) > 0 {
+		suspicious = fmt.Sprintf("\nThe backup is suspicious\n%v", strings.Join(anomalies, "\n"))
+	}
+
+	if err == nil && res.Status == 206 {
+		status = "206"
+		log = fmt.Sprintf("Backup partially finished in %v archive %v size %v\n%v",
+			res.Duration, res.Name, humanize.Bytes(uint64(res.Size)), res.Summary())
+
+		logrus.WithField("plan", b.plan.Name).Warn(log + suspicious)
+		if err := b.sch.Notifier.SendNotification(fmt.Sprintf("%v backup partially failed", b.plan.Name),
+			fmt.Sprintf("%v backup finished in %v archive size %v\n%v%v",
+				res.Name, res.Duration, humanize.Bytes(uint64(res.Size)), res.Summary(), suspicious),
+			true, b.plan); err != nil {
+			logrus.WithField("plan", b.plan.Name).Errorf("Notifier failed %v", err)
+		}
+	} else if err == nil {
 		log = fmt.Sprintf("Backup finished in %v archive %v size %v",
 			res.Duration, res.Name, humanize.Bytes(uint64(res.Size)))
 
-		logrus.WithField("plan", b.plan.Name).Info(log)
-		if err := notifier.SendNotification(fmt.Sprintf("%v backup finished", b.plan.Name),
-			fmt.Sprintf("%v backup finished in %v archive size %v",
-				res.Name, res.Duration, humanize.Bytes(uint64(res.Size))),
-			false, b.plan); err != nil {
+		subject := fmt.Sprintf("%v backup finished", b.plan.Name)
+		if suspicious != "" {
+			subject = fmt.Sprintf("%v backup suspicious", b.plan.Name)
+			logrus.WithField("plan", b.plan.Name).Warn(log + suspicious)
+		} else {
+			logrus.WithField("plan", b.plan.Name).Info(log)
+		}
+		if err := b.sch.Notifier.SendNotification(subject,
+			fmt.Sprintf("%v backup finished in %v archive size %v%v",
+				res.Name, res.Duration, humanize.Bytes(uint64(res.Size)), suspicious),
+			suspicious != "", b.plan); err != nil {
 			logrus.WithField("plan", b.plan.Name).Errorf("Notifier failed %v", err)
 		}
 	}
 
-	t2 := time.Now()
-	b.metrics.Total.WithLabelValues(b.plan.Name, status).Inc()
-	b.metrics.Latency.WithLabelValues(b.plan.Name, status).Observe(t2.Sub(t1).Seconds())
+	if err := backup.UpdateCatalog(b.catalog, res); err != nil {
+		logrus.WithField("plan", b.plan.Name).Errorf("Catalog update failed %v", err)
+	}
 
-	s := &db.Status{
-		LastRun:       &res.Timestamp,
-		LastRunStatus: status,
-		Plan:          b.plan.Name,
-		LastRunLog:    log,
+	b.sch.Record(b.plan, res, status, log, anomalies, time.Now().Sub(t1))
+}
+
+// Running counts a run of the plan in progress until the returned func is called
+func (s *Scheduler) Running(name string) func() {
+	s.metrics.Running.WithLabelValues(name).Inc()
+	return func() {
+		s.metrics.Running.WithLabelValues(name).Dec()
 	}
+}
 
-	for _, e := range b.cron.Entries() {
-		switch e.Job.(type) {
-		case backupJob:
-			if e.Job.(backupJob).name == b.plan.Name {
-				s.NextRun = e.Next
-				break
+// Record saves the outcome of a scheduled or on demand run in the metrics and the status store,
+// the last success moves only when every archive of the run was stored, a partial perDatabase run
+// missing a database keeps the previous one
+func (s *Scheduler) Record(plan config.Plan, res backup.Result, status string, log string, anomalies []string, duration time.Duration) {
+	s.metrics.Total.WithLabelValues(plan.Name, status).Inc()
+	s.metrics.Latency.WithLabelValues(plan.Name, status).Observe(duration.Seconds())
+	for _, d := range res.Destinations {
+		s.metrics.Destination.WithLabelValues(plan.Name, d.Name, strconv.Itoa(d.Status)).Inc()
+	}
+	for _, db := range res.Databases {
+		for _, d := range db.Destinations {
+			s.metrics.Destination.WithLabelValues(plan.Name, d.Name, strconv.Itoa(d.Status)).Inc()
+		}
+	}
+	if status != "204" {
+		s.observe(plan.Name, res)
+	}
+	s.metrics.LastRun.WithLabelValues(plan.Name).Set(float64(res.Timestamp.Unix()))
+	code, _ := strconv.Atoi(status)
+	s.metrics.LastRunStatus.WithLabelValues(plan.Name).Set(float64(code))
+
+	st := &db.Status{
+		LastRun:          &res.Timestamp,
+		LastRunStatus:    status,
+		Plan:             plan.Name,
+		LastRunLog:       redact.String(log),
+		LastRunMember:    res.Member,
+		LastRunAnomalies: anomalies,
+	}
+
+	if (status == "200" || status == "206") && res.Stored() {
+		st.LastSuccess = &res.Timestamp
+		s.metrics.LastSuccess.WithLabelValues(plan.Name).Set(float64(res.Timestamp.Unix()))
+	} else if prev, err := s.Stats.Get(plan.Name); err == nil && prev != nil {
+		st.LastSuccess = prev.LastSuccess
+	}
+
+	st.NextRun = s.nextRun(plan.Name)
+
+	logrus.WithField("plan", plan.Name).Infof("Next run at %v", st.NextRun)
+	if err := s.Stats.Put(st); err != nil {
+		logrus.WithField("plan", plan.Name).Errorf("Status store failed %v", err)
+	}
+}
+
+// checkAnomalies compares a successful or partial run with the plan history and records it
+func (b backupJob) checkAnomalies(res backup.Result) []string {
+	sample, err := backup.NewSample(b.plan, res, filepath.Clean(b.conf.StoragePath))
+	if err == backup.ErrNoSample {
+		logrus.WithField("plan", b.plan.Name).Warnf("Anomaly detection skipped, %v", err)
+		return nil
+	}
+	if err != nil {
+		logrus.WithField("plan", b.plan.Name).Errorf("Reading the archive for the anomaly detection failed %v", err)
+		return nil
+	}
+	history, err := b.stats.History(b.plan.Name)
+	if err != nil {
+		logrus.WithField("plan", b.plan.Name).Errorf("Status store history failed %v", err)
+		return nil
+	}
+
+	anomalies := backup.Anomalies(*b.plan.Anomaly, sample, history)
+	if err := b.stats.AddSample(b.plan.Name, sample, b.plan.Anomaly.Runs()); err != nil {
+		logrus.WithField("plan", b.plan.Name).Errorf("Status store history failed %v", err)
+	}
+
+	b.metrics.Anomalies.WithLabelValues(b.plan.Name).Add(float64(len(anomalies)))
+	if len(anomalies) < 1 {
+		b.metrics.Suspicious.WithLabelValues(b.plan.Name).Set(0)
+		return nil
+	}
+	b.metrics.Suspicious.WithLabelValues(b.plan.Name).Set(1)
+
+	return anomalies
+}
+
+// observe records the stage durations, the archive size, the bytes uploaded and the retention deletions of a run
+func (s *Scheduler) observe(name string, res backup.Result) {
+	results := append([]backup.Result{res}, res.Databases...)
+	for _, r := range results {
+		for stage, d := range r.Stages {
+			s.metrics.Stage.WithLabelValues(name, stage, "").Observe(d.Seconds())
+		}
+		for _, d := range r.Destinations {
+			if d.Name == "local" {
+				s.metrics.Stage.WithLabelValues(name, "local", "").Observe(d.Duration.Seconds())
+				continue
+			}
+			s.metrics.Stage.WithLabelValues(name, "upload", d.Name).Observe(d.Duration.Seconds())
+			if d.Status == 200 {
+				s.metrics.UploadedBytes.WithLabelValues(name, d.Name).Add(float64(r.Size))
 			}
 		}
+		s.metrics.Retention.WithLabelValues(name).Add(float64(len(r.Pruned)))
 	}
+	if res.Size > 0 {
+		s.metrics.ArchiveSize.WithLabelValues(name).Set(float64(res.Size))
+	}
+}
 
-	logrus.WithField("plan", b.plan.Name).Infof("Next run at %v", s.NextRun)
-	if err := b.stats.Put(s); err != nil {
-		logrus.WithField("plan", b.plan.Name).Errorf("Status store failed %v", err)
+// initMetrics sets the gauges from the status store and the catalog,
+// the dashboards keep the last run of every plan across restarts
+func (s *Scheduler) initMetrics() {
+	anomaly := make(map[string]bool)
+	for _, plan := range s.Plans {
+		anomaly[plan.Name] = plan.Anomaly != nil
 	}
+
+	stats, err := s.Stats.GetAll()
+	if err != nil {
+		logrus.Errorf("Loading the metrics from the status store failed %v", err)
+		return
+	}
+	for _, st := range stats {
+		if st.LastRun != nil {
+			s.metrics.LastRun.WithLabelValues(st.Plan).Set(float64(st.LastRun.Unix()))
+			if code, err := strconv.Atoi(st.LastRunStatus); err == nil {
+				s.metrics.LastRunStatus.WithLabelValues(st.Plan).Set(float64(code))
+			}
+		}
+		if last := lastSuccess(st); last != nil {
+			s.metrics.LastSuccess.WithLabelValues(st.Plan).Set(float64(last.Unix()))
+		}
+		if anomaly[st.Plan] && st.LastRunStatus == "200" {
+			suspicious := 0.0
+			if len(st.LastRunAnomalies) > 0 {
+				suspicious = 1
+			}
+			s.metrics.Suspicious.WithLabelValues(st.Plan).Set(suspicious)
+		}
+
+		// the archives of the newest run, one per database for the perDatabase plans
+		backups, err := s.Catalog.List(db.CatalogFilter{Plan: st.Plan})
+		if err != nil {
+			logrus.WithField("plan", st.Plan).Errorf("Loading the metrics from the catalog failed %v", err)
+			continue
+		}
+		if len(backups) < 1 {
+			continue
+		}
+		size := backups[0].Size
+		for _, b := range backups[1:] {
+			if b.RunID != "" && b.RunID == backups[0].RunID {
+				size += b.Size
+			}
+		}
+		s.metrics.ArchiveSize.WithLabelValues(st.Plan).Set(float64(size))
+	}
+}
+
+// forgetMetrics drops the gauges of a removed plan
+func (s *Scheduler) forgetMetrics(plan string) {
+	s.metrics.ArchiveSize.DeleteLabelValues(plan)
+	s.metrics.LastRun.DeleteLabelValues(plan)
+	s.metrics.LastRunStatus.DeleteLabelValues(plan)
+	s.metrics.Suspicious.DeleteLabelValues(plan)
 }
@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vtomasr5/mgob/backup"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+)
+
+func TestRecord(t *testing.T) {
+	previous := time.Date(2018, 1, 10, 6, 0, 0, 0, time.UTC)
+	ts := previous.Add(time.Hour)
+	plan := config.Plan{Name: "mongo"}
+	ok := backup.Destination{Name: "local", Status: 200}
+	failed := backup.Destination{Name: "s3", Status: 500}
+
+	tests := []struct {
+		name   string
+		res    backup.Result
+		status string
+		want   time.Time
+	}{
+		{
+			name:   "successful run",
+			res:    backup.Result{Status: 200, Timestamp: ts, Destinations: []backup.Destination{ok}},
+			status: "200",
+			want:   ts,
+		},
+		{
+			name:   "failed copy",
+			res:    backup.Result{Status: 206, Timestamp: ts, Destinations: []backup.Destination{ok, failed}},
+			status: "206",
+			want:   ts,
+		},
+		{
+			name: "failed database",
+			res: backup.Result{Status: 206, Timestamp: ts, Databases: []backup.Result{
+				{Database: "orders", Status: 200, Destinations: []backup.Destination{ok}},
+				{Database: "users", Status: 500},
+			}},
+			status: "206",
+			want:   previous,
+		},
+		{
+			name: "failed copy of a database",
+			res: backup.Result{Status: 206, Timestamp: ts, Databases: []backup.Result{
+				{Database: "orders", Status: 200, Destinations: []backup.Destination{ok}},
+				{Database: "users", Status: 206, Destinations: []backup.Destination{ok, failed}},
+			}},
+			status: "206",
+			want:   ts,
+		},
+		{
+			name:   "failed run",
+			res:    backup.Result{Status: 500, Timestamp: ts},
+			status: "500",
+			want:   previous,
+		},
+		{
+			name:   "skipped run",
+			res:    backup.Result{Status: 500, Timestamp: ts},
+			status: "204",
+			want:   previous,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testScheduler(t)
+			if err := s.Stats.Put(&db.Status{Plan: "mongo", LastSuccess: &previous}); err != nil {
+				t.Fatal(err)
+			}
+
+			s.Record(plan, tt.res, tt.status, "", nil, time.Second)
+
+			st, err := s.Stats.Get("mongo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.LastRunStatus != tt.status || st.LastRun == nil || !st.LastRun.Equal(ts) {
+				t.Errorf("last run %v status %v, want %v status %v", st.LastRun, st.LastRunStatus, ts, tt.status)
+			}
+			if st.LastSuccess == nil || !st.LastSuccess.Equal(tt.want) {
+				t.Errorf("last success %v, want %v", st.LastSuccess, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+)
+
+// watchInterval is how often the watchdog checks the age of the last successful backups
+const watchInterval = time.Minute
+
+// watch checks the RPO of the plans with a max age, it notifies when the last successful
+// backup gets too old and again when a backup lands. A plan without any successful
+// backup is measured from the watchdog start.
+func (s *Scheduler) watch() {
+	started := time.Now()
+	breached := make(map[string]bool)
+	watched := make(map[string]bool)
+
+	s.checkRPO(started, breached, watched)
+	for range time.Tick(watchInterval) {
+		s.checkRPO(started, breached, watched)
+	}
+}
+
+func (s *Scheduler) checkRPO(started time.Time, breached map[string]bool, watched map[string]bool) {
+	s.mu.Lock()
+	plans := s.Plans
+	s.mu.Unlock()
+
+	stats, err := s.Stats.GetAll()
+	if err != nil {
+		logrus.Errorf("RPO watchdog status store failed %v", err)
+		return
+	}
+	last := make(map[string]*time.Time)
+	for _, st := range stats {
+		last[st.Plan] = lastSuccess(st)
+	}
+
+	now := time.Now()
+	current := make(map[string]bool)
+	for _, plan := range plans {
+		current[plan.Name] = true
+		watched[plan.Name] = true
+		ls := last[plan.Name]
+		if ls != nil {
+			s.metrics.LastSuccess.WithLabelValues(plan.Name).Set(float64(ls.Unix()))
+		}
+		if plan.Scheduler.MaxAge < 1 {
+			s.metrics.RPOBreached.DeleteLabelValues(plan.Name)
+			delete(breached, plan.Name)
+			continue
+		}
+
+		maxAge := time.Duration(plan.Scheduler.MaxAge) * time.Minute
+		since := started
+		if ls != nil {
+			since = *ls
+		}
+		age := now.Sub(since)
+		isBreached := age > maxAge
+		if isBreached {
+			s.metrics.RPOBreached.WithLabelValues(plan.Name).Set(1)
+		} else {
+			s.metrics.RPOBreached.WithLabelValues(plan.Name).Set(0)
+		}
+		if isBreached == breached[plan.Name] {
+			continue
+		}
+		breached[plan.Name] = isBreached
+		s.notifyRPO(plan, isBreached, ls, age.Truncate(time.Second), maxAge)
+	}
+
+	// the plans removed from the config dir
+	for name := range watched {
+		if !current[name] {
+			s.metrics.LastSuccess.DeleteLabelValues(name)
+			s.metrics.RPOBreached.DeleteLabelValues(name)
+			delete(breached, name)
+			delete(watched, name)
+		}
+	}
+}
+
+// lastSuccess returns the last successful run, the last run is used for the status
+// records written before the last success was tracked, a partial run of those records
+// may miss a database so only a complete one counts
+func lastSuccess(st *db.Status) *time.Time {
+	if st.LastSuccess != nil {
+		return st.LastSuccess
+	}
+	if st.LastRun != nil && st.LastRunStatus == "200" {
+		return st.LastRun
+	}
+	return nil
+}
+
+func (s *Scheduler) notifyRPO(plan config.Plan, breached bool, last *time.Time, age time.Duration, maxAge time.Duration) {
+	var subject, body string
+	switch {
+	case breached && last == nil:
+		subject = fmt.Sprintf("%v backup RPO breached", plan.Name)
+		body = fmt.Sprintf("No successful backup since mgob started %v ago, the max age is %v", age, maxAge)
+	case breached:
+		subject = fmt.Sprintf("%v backup RPO breached", plan.Name)
+		body = fmt.Sprintf("The last successful backup started %v ago at %v, the max age is %v",
+			age, last.UTC().Format(time.RFC3339), maxAge)
+	case last == nil:
+		// the max age was raised by a plan reload
+		subject = fmt.Sprintf("%v backup RPO recovered", plan.Name)
+		body = fmt.Sprintf("No successful backup since mgob started %v ago, the max age is now %v", age, maxAge)
+	default:
+		subject = fmt.Sprintf("%v backup RPO recovered", plan.Name)
+		body = fmt.Sprintf("A backup succeeded at %v, the max age is %v", last.UTC().Format(time.RFC3339), maxAge)
+	}
+
+	if breached {
+		logrus.WithField("plan", plan.Name).Warn(body)
+	} else {
+		logrus.WithField("plan", plan.Name).Info(body)
+	}
+	if err := s.Notifier.SendNotification(subject, body, breached, plan); err != nil {
+		logrus.WithField("plan", plan.Name).Errorf("Notifier failed %v", err)
+	}
+}
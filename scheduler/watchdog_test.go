@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vtomasr5/mgob/config"
+	"github.com/vtomasr5/mgob/db"
+)
+
+var (
+	testSchedulerOnce sync.Once
+	testSchedulerInst *Scheduler
+)
+
+// testScheduler returns the scheduler shared by the tests with an empty status store, the
+// scheduler metrics can only be registered once
+func testScheduler(t *testing.T) *Scheduler {
+	dir, err := ioutil.TempDir("", "mgob-scheduler-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	store, err := db.Open(filepath.Join(dir, "mgob.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	stats, err := db.NewStatusStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSchedulerOnce.Do(func() {
+		testSchedulerInst = New(nil, &config.AppConfig{}, nil, nil)
+	})
+	testSchedulerInst.Stats = stats
+	testSchedulerInst.Plans = nil
+	return testSchedulerInst
+}
+
+func TestCheckRPO(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		ts := now.Add(-d)
+		return &ts
+	}
+	plan := func(maxAge int) config.Plan {
+		p := config.Plan{Name: "mongo"}
+		p.Scheduler.MaxAge = maxAge
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		plan     config.Plan
+		status   *db.Status
+		started  time.Time
+		breached bool
+		want     bool
+	}{
+		{
+			name:    "recent success",
+			plan:    plan(60),
+			status:  &db.Status{Plan: "mongo", LastSuccess: ago(30 * time.Minute)},
+			started: now,
+			want:    false,
+		},
+		{
+			name:    "old success",
+			plan:    plan(60),
+			status:  &db.Status{Plan: "mongo", LastSuccess: ago(2 * time.Hour)},
+			started: now,
+			want:    true,
+		},
+		{
+			name:     "success after a breach",
+			plan:     plan(60),
+			status:   &db.Status{Plan: "mongo", LastSuccess: ago(time.Minute)},
+			started:  now,
+			breached: true,
+			want:     false,
+		},
+		{
+			name:    "failed runs keep the last success",
+			plan:    plan(60),
+			status:  &db.Status{Plan: "mongo", LastRun: ago(time.Minute), LastRunStatus: "500", LastSuccess: ago(2 * time.Hour)},
+			started: now,
+			want:    true,
+		},
+		{
+			name:    "never succeeded since the start",
+			plan:    plan(60),
+			started: now.Add(-2 * time.Hour),
+			want:    true,
+		},
+		{
+			name:    "started recently",
+			plan:    plan(60),
+			started: now.Add(-time.Minute),
+			want:    false,
+		},
+		{
+			name:    "legacy record with a complete run",
+			plan:    plan(60),
+			status:  &db.Status{Plan: "mongo", LastRun: ago(time.Minute), LastRunStatus: "200"},
+			started: now.Add(-2 * time.Hour),
+			want:    false,
+		},
+		{
+			name:    "legacy record with a partial run",
+			plan:    plan(60),
+			status:  &db.Status{Plan: "mongo", LastRun: ago(time.Minute), LastRunStatus: "206"},
+			started: now.Add(-2 * time.Hour),
+			want:    true,
+		},
+		{
+			name:     "max age removed",
+			plan:     plan(0),
+			status:   &db.Status{Plan: "mongo", LastSuccess: ago(2 * time.Hour)},
+			started:  now,
+			breached: true,
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testScheduler(t)
+			s.Plans = []config.Plan{tt.plan}
+			if tt.status != nil {
+				if err := s.Stats.Put(tt.status); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			breached := map[string]bool{"mongo": tt.breached}
+			watched := map[string]bool{"mongo": true, "removed": true}
+			s.checkRPO(tt.started, breached, watched)
+
+			if breached["mongo"] != tt.want {
+				t.Errorf("breached %v, want %v", breached["mongo"], tt.want)
+			}
+			if watched["removed"] {
+				t.Errorf("the removed plan is still watched")
+			}
+		})
+	}
+}